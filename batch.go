@@ -0,0 +1,66 @@
+package boilerplate
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// The most bind parameters a single statement may use.
+var maxParams = map[Driver]int{
+	DriverSqlite:   32766,
+	DriverPostgres: 65535,
+}
+
+// Inserts all `rows` using multi-row `INSERT ... VALUES (...), (...) RETURNING *` statements, and returns the inserted rows with their auto generated columns filled in.
+//
+// Neither sqlite nor postgres guarantees the order of the rows returned by `RETURNING`, so the returned rows may not be in the same order as `rows`. Match them up by a unique column when needed.
+//
+// `opts` are the same options passed to `GenerateQueries`, `opts.Model` defaults to T. Rows are split into as few statements as the bind parameter limit of `opts.Driver` allows, all run inside one transaction (or a savepoint, if a transaction is already open), so either every row is inserted or none are.
+func InsertMany[T any](db Querier, opts GenerateQueriesOptions, rows []T) ([]T, error) {
+	return InsertManyContext(context.Background(), db, opts, rows)
+}
+
+func InsertManyContext[T any](ctx context.Context, db Querier, opts GenerateQueriesOptions, rows []T) (inserted []T, err error) {
+	if len(rows) == 0 {
+		return []T{}, nil
+	}
+	if opts.Model == nil {
+		opts.Model = *new(T)
+	}
+
+	insertCols := 0
+	for _, c := range modelColumns(opts) {
+		if !slices.Contains(opts.AutoGeneratingCols, c.name) {
+			insertCols++
+		}
+	}
+	if insertCols == 0 {
+		return nil, fmt.Errorf("no columns to insert for table: %s", opts.TableName)
+	}
+
+	limit, ok := maxParams[opts.Driver]
+	if !ok {
+		limit = min(maxParams[DriverSqlite], maxParams[DriverPostgres])
+	}
+	chunkSize := max(limit/insertCols, 1)
+
+	queries := GenerateQueries(opts)
+	inserted = make([]T, 0, len(rows))
+	err = WithTx(ctx, db, nil, func(ctx context.Context, _ *sqlx.Tx) error {
+		for chunk := range slices.Chunk(rows, chunkSize) {
+			out, err := NamedSelectReturningContext[T](ctx, db, queries.Insert, chunk)
+			if err != nil {
+				return err
+			}
+			inserted = append(inserted, out...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inserted, nil
+}
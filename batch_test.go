@@ -0,0 +1,120 @@
+package boilerplate
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestInsertMany(t *testing.T) {
+	LoadDB(t)
+
+	type Table struct {
+		ID    int    `db:"id" dbtype:"BIGSERIAL NOT NULL PRIMARY KEY"`
+		Name  string `db:"name" dbtype:"TEXT NOT NULL UNIQUE"`
+		Value int    `db:"value" dbtype:"INT NOT NULL"`
+		Note  string `db:"note" dbtype:"TEXT NOT NULL"`
+	}
+	opts := GenerateQueriesOptions{
+		TableName:          "table_insert_many",
+		AutoGeneratingCols: []string{"id"},
+		PrimaryKeys:        []string{"id"},
+		Driver:             DriverSqlite,
+	}
+	queries := GenerateQueries(GenerateQueriesOptions{
+		TableName:          opts.TableName,
+		Model:              Table{},
+		AutoGeneratingCols: opts.AutoGeneratingCols,
+		PrimaryKeys:        opts.PrimaryKeys,
+		Driver:             opts.Driver,
+	})
+
+	t.Cleanup(func() {
+		_ = Exec(db, queries.DropTable)
+	})
+
+	err := Exec(db, queries.CreateTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("chunks over the parameter limit", func(t *testing.T) {
+		t.Cleanup(func() { _ = Exec(db, "DELETE FROM table_insert_many") })
+
+		// 3 columns per row, so this needs more than one statement to stay under sqlite's limit
+		n := maxParams[DriverSqlite]/3 + 100
+		rows := make([]Table, n)
+		for i := range rows {
+			rows[i] = Table{Name: fmt.Sprintf("row %d", i), Value: i, Note: "chunked"}
+		}
+
+		inserted, err := InsertMany(db, opts, rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(inserted) != n {
+			t.Fatalf("Expected %d inserted rows, got %d", n, len(inserted))
+		}
+		// the returned order is not guaranteed, so rows are matched up by their unique name
+		byName := map[string]Table{}
+		ids := map[int]bool{}
+		for _, row := range inserted {
+			if row.ID == 0 {
+				t.Fatalf("Expected inserted row %+v to have an id, but it's still 0.", row)
+			}
+			byName[row.Name] = row
+			ids[row.ID] = true
+		}
+		for _, row := range rows {
+			if got, ok := byName[row.Name]; !ok || got.Value != row.Value {
+				t.Fatalf("Expected %+v to be returned, got %+v", row, got)
+			}
+		}
+		if len(ids) != n {
+			t.Fatalf("Expected %d distinct ids, got %d", n, len(ids))
+		}
+
+		count, err := Get[int](db, "SELECT COUNT(*) FROM table_insert_many")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != n {
+			t.Fatalf("Expected %d rows in the table, got %d", n, count)
+		}
+	})
+
+	t.Run("failure rolls back every chunk", func(t *testing.T) {
+		t.Cleanup(func() { _ = Exec(db, "DELETE FROM table_insert_many") })
+
+		n := maxParams[DriverSqlite]/3 + 100
+		rows := make([]Table, n)
+		for i := range rows {
+			rows[i] = Table{Name: fmt.Sprintf("row %d", i), Value: i}
+		}
+		// duplicate name in the last chunk
+		rows[n-1].Name = rows[0].Name
+
+		_, err := InsertMany(db, opts, rows)
+		if !errors.Is(err, ErrUniqueViolation) {
+			t.Fatalf("Expected a unique violation, got: %v", err)
+		}
+
+		count, err := Get[int](db, "SELECT COUNT(*) FROM table_insert_many")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Fatalf("Expected every chunk to be rolled back, got %d rows", count)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		inserted, err := InsertMany(db, opts, []Table{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(inserted) != 0 {
+			t.Fatalf("Expected no inserted rows, got %d", len(inserted))
+		}
+	})
+}
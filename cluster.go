@@ -0,0 +1,212 @@
+package boilerplate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// How `Cluster` picks the replica to read from.
+type RoutingStrategy int
+
+const (
+	// Takes turns between the healthy replicas.
+	RoundRobin RoutingStrategy = iota
+	// Picks the healthy replica with the lowest health check latency, see `ClusterOptions.HealthCheckInterval`.
+	LeastLatency
+)
+
+type ClusterOptions struct {
+	// Used for the primary and every replica, see `NewDB`.
+	DB       DBOptions
+	Strategy RoutingStrategy
+	// How often every replica is pinged. A replica that fails its ping stops receiving reads until it passes one again. Defaults to 5 seconds, a negative value disables the background checks, see `Cluster.CheckHealth`.
+	HealthCheckInterval time.Duration
+	// Defaults to 1 second.
+	HealthCheckTimeout time.Duration
+}
+
+// A primary with read replicas, which can be passed to the execute helpers like a single database.
+//
+// GET, SELECT, NAMED_GET, NAMED_SELECT and SELECT_ITER run on a healthy replica, everything else and every transaction opened by `WithTx` runs on the primary. Reads fall back to the primary when no replica is healthy, and can be sent to the primary with `WithReadYourWrites`, i.e. right after a write that replicas may not have caught up with yet.
+type Cluster struct {
+	primary  *DB
+	replicas []*replica
+	opts     ClusterOptions
+	next     atomic.Uint64
+
+	stop      chan struct{}
+	stopped   sync.WaitGroup
+	closeOnce sync.Once
+}
+
+type replica struct {
+	db      *DB
+	healthy atomic.Bool
+	// Moving average of the health check latency in nanoseconds, 0 until the first check.
+	latency atomic.Int64
+}
+
+var _ Querier = (*Cluster)(nil)
+
+func NewCluster(primary *sqlx.DB, replicas []*sqlx.DB, opts ClusterOptions) *Cluster {
+	if opts.HealthCheckInterval == 0 {
+		opts.HealthCheckInterval = 5 * time.Second
+	}
+	if opts.HealthCheckTimeout <= 0 {
+		opts.HealthCheckTimeout = time.Second
+	}
+	c := &Cluster{
+		primary: NewDB(primary, opts.DB),
+		opts:    opts,
+		stop:    make(chan struct{}),
+	}
+	for _, db := range replicas {
+		r := &replica{db: NewDB(db, opts.DB)}
+		r.healthy.Store(true)
+		c.replicas = append(c.replicas, r)
+	}
+
+	if opts.HealthCheckInterval > 0 && len(c.replicas) > 0 {
+		c.stopped.Add(1)
+		go c.healthChecks()
+	}
+	return c
+}
+
+func (c *Cluster) Primary() *DB {
+	return c.primary
+}
+
+// Pings every replica, ejecting the ones that fail and bringing back the ones that pass. This is run every `ClusterOptions.HealthCheckInterval`.
+func (c *Cluster) CheckHealth(ctx context.Context) {
+	wg := sync.WaitGroup{}
+	for _, r := range c.replicas {
+		wg.Go(func() {
+			ctx, cancel := context.WithTimeout(ctx, c.opts.HealthCheckTimeout)
+			defer cancel()
+			start := time.Now()
+			err := r.db.PingContext(ctx)
+			if err != nil {
+				r.healthy.Store(false)
+				return
+			}
+			r.healthy.Store(true)
+			sample := int64(time.Since(start))
+			if prev := r.latency.Load(); prev != 0 {
+				sample = (prev*7 + sample*3) / 10
+			}
+			r.latency.Store(sample)
+		})
+	}
+	wg.Wait()
+}
+
+func (c *Cluster) healthChecks() {
+	defer c.stopped.Done()
+	ticker := time.NewTicker(c.opts.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.CheckHealth(context.Background())
+		}
+	}
+}
+
+// Stops the health checks and closes the primary and every replica.
+func (c *Cluster) Close() error {
+	c.closeOnce.Do(func() { close(c.stop) })
+	c.stopped.Wait()
+	errs := []error{c.primary.Close()}
+	for _, r := range c.replicas {
+		errs = append(errs, r.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+type readYourWritesKey struct{}
+
+// Sends the reads of a `Cluster` made with the returned context to the primary, so they see the writes made before them.
+func WithReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readYourWritesKey{}, true)
+}
+
+// Implemented by handles that run reads and writes on different databases, i.e. `Cluster` and `SqlitePool`. The execute helpers resolve the database to run on with this.
+type router interface {
+	route(ctx context.Context, write bool) *DB
+}
+
+func (c *Cluster) route(ctx context.Context, write bool) *DB {
+	if write || ctx.Value(readYourWritesKey{}) != nil {
+		return c.primary
+	}
+
+	switch c.opts.Strategy {
+	case LeastLatency:
+		var best *replica
+		for _, r := range c.replicas {
+			if r.healthy.Load() && (best == nil || r.latency.Load() < best.latency.Load()) {
+				best = r
+			}
+		}
+		if best != nil {
+			return best.db
+		}
+	default:
+		n := uint64(len(c.replicas))
+		start := c.next.Add(1)
+		for i := range n {
+			if r := c.replicas[(start+i)%n]; r.healthy.Load() {
+				return r.db
+			}
+		}
+	}
+	return c.primary
+}
+
+// The helpers that only read, and can run on a replica.
+var readOps = map[string]bool{
+	"GET":          true,
+	"SELECT":       true,
+	"NAMED_GET":    true,
+	"NAMED_SELECT": true,
+	"SELECT_ITER":  true,
+}
+
+// The methods below make `Cluster` a `Querier` when used directly rather than through the execute helpers. Reads are routed like the helpers, `ExecContext` runs on the primary.
+
+func (c *Cluster) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return c.route(ctx, false).QueryContext(ctx, query, args...)
+}
+
+func (c *Cluster) QueryxContext(ctx context.Context, query string, args ...any) (*sqlx.Rows, error) {
+	return c.route(ctx, false).QueryxContext(ctx, query, args...)
+}
+
+func (c *Cluster) QueryRowxContext(ctx context.Context, query string, args ...any) *sqlx.Row {
+	return c.route(ctx, false).QueryRowxContext(ctx, query, args...)
+}
+
+func (c *Cluster) GetContext(ctx context.Context, dest any, query string, args ...any) error {
+	return c.route(ctx, false).GetContext(ctx, dest, query, args...)
+}
+
+func (c *Cluster) SelectContext(ctx context.Context, dest any, query string, args ...any) error {
+	return c.route(ctx, false).SelectContext(ctx, dest, query, args...)
+}
+
+func (c *Cluster) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return c.primary.ExecContext(ctx, query, args...)
+}
+
+func (c *Cluster) Rebind(query string) string {
+	return c.primary.Rebind(query)
+}
@@ -0,0 +1,153 @@
+package boilerplate
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestCluster(t *testing.T) {
+	dir := t.TempDir()
+	open := func(name string) *sqlx.DB {
+		db, err := Connect(DriverSqlite, filepath.Join(dir, name+".db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// each file stands in for a server, so they only differ in what they answer
+		for _, query := range []string{"CREATE TABLE t (name TEXT NOT NULL)", "INSERT INTO t (name) VALUES ('" + name + "')"} {
+			if err := Exec(db, query); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return db
+	}
+
+	calls := []string{}
+	hook := &recordingHook{name: "hook", calls: &calls}
+	cluster := NewCluster(open("primary"), []*sqlx.DB{open("replica1"), open("replica2")}, ClusterOptions{
+		DB:                  DBOptions{Hooks: []QueryHook{hook}},
+		HealthCheckInterval: -1,
+	})
+	t.Cleanup(func() { _ = cluster.Close() })
+
+	// the name of the database each read runs on
+	reads := func(ctx context.Context, n int) []string {
+		names := []string{}
+		for range n {
+			name, err := GetContext[string](ctx, cluster, "SELECT name FROM t LIMIT 1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, name)
+		}
+		return names
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		names := reads(context.Background(), 4)
+		if names[0] == names[1] || names[0] != names[2] || names[1] != names[3] || names[0] == "primary" || names[1] == "primary" {
+			t.Fatalf("Expected reads to alternate between the replicas, got %v", names)
+		}
+		if len(hook.events) == 0 {
+			t.Fatal("Expected the hooks of the cluster to run")
+		}
+	})
+
+	t.Run("writes", func(t *testing.T) {
+		if err := Exec(cluster, "UPDATE t SET name = name || '!'"); err != nil {
+			t.Fatal(err)
+		}
+		updated, err := SelectReturning[string](cluster, "UPDATE t SET name = TRIM(name, '!') RETURNING name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertStructEqual(t, []string{"primary"}, updated, "Expected writes to run on the primary")
+	})
+
+	t.Run("read your writes", func(t *testing.T) {
+		AssertStructEqual(t, []string{"primary", "primary"}, reads(WithReadYourWrites(context.Background()), 2), "Expected reads to run on the primary")
+	})
+
+	t.Run("transactions", func(t *testing.T) {
+		err := WithTx(context.Background(), cluster, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+			AssertStructEqual(t, []string{"primary", "primary"}, reads(ctx, 2), "Expected reads in a transaction to run on the primary")
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ejection", func(t *testing.T) {
+		cluster.replicas[0].healthy.Store(false)
+		names := reads(context.Background(), 3)
+		if names[0] != names[1] || names[1] != names[2] || names[0] == "primary" {
+			t.Fatalf("Expected reads to only run on the healthy replica, got %v", names)
+		}
+
+		cluster.replicas[1].healthy.Store(false)
+		AssertStructEqual(t, []string{"primary", "primary"}, reads(context.Background(), 2), "Expected reads to fall back to the primary")
+
+		cluster.CheckHealth(context.Background())
+		if !cluster.replicas[0].healthy.Load() || !cluster.replicas[1].healthy.Load() {
+			t.Fatal("Expected replicas that pass their health check to be brought back")
+		}
+	})
+
+	t.Run("least latency", func(t *testing.T) {
+		cluster.opts.Strategy = LeastLatency
+		t.Cleanup(func() { cluster.opts.Strategy = RoundRobin })
+
+		cluster.replicas[0].latency.Store(int64(time.Second))
+		cluster.replicas[1].latency.Store(int64(time.Millisecond))
+		names := reads(context.Background(), 3)
+		if names[0] != names[1] || names[1] != names[2] || names[0] == "primary" {
+			t.Fatalf("Expected reads to run on the fastest replica, got %v", names)
+		}
+		if names[0] != reads(context.Background(), 1)[0] {
+			t.Fatal("Expected the fastest replica to be picked every time")
+		}
+
+		cluster.replicas[1].healthy.Store(false)
+		if got := reads(context.Background(), 1)[0]; got == names[0] || got == "primary" {
+			t.Fatalf("Expected reads to move to the other replica, got %s", got)
+		}
+		cluster.replicas[1].healthy.Store(true)
+	})
+
+	t.Run("health checks", func(t *testing.T) {
+		replica := open("replica3")
+		checked := NewCluster(open("primary2"), []*sqlx.DB{replica}, ClusterOptions{
+			DB:                  DBOptions{Hooks: []QueryHook{}},
+			HealthCheckInterval: time.Millisecond,
+		})
+		t.Cleanup(func() { _ = checked.Close() })
+
+		deadline := time.Now().Add(time.Second)
+		for checked.replicas[0].latency.Load() == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if checked.replicas[0].latency.Load() == 0 {
+			t.Fatal("Expected the background health checks to measure the latency")
+		}
+
+		_ = replica.Close()
+		deadline = time.Now().Add(time.Second)
+		for checked.replicas[0].healthy.Load() && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if checked.replicas[0].healthy.Load() {
+			t.Fatal("Expected the closed replica to be ejected")
+		}
+		name, err := Get[string](checked, "SELECT name FROM t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != "primary2" {
+			t.Fatalf("Expected the read to fall back to the primary, got %s", name)
+		}
+	})
+}
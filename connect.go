@@ -1,11 +1,313 @@
 package boilerplate
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
-	_ "modernc.org/sqlite"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog"
 )
 
+func init() {
+	// sqlx does not know modernc's driver name, so it would leave queries unbound on sqlite
+	sqlx.BindDriver(DriverSqlite, sqlx.QUESTION)
+}
+
 func Connect(driver Driver, connString string) (*sqlx.DB, error) {
 	return sqlx.Connect(string(driver), connString)
 }
+
+type ConnectOptions struct {
+	// 0 means no limit.
+	MaxOpenConns int
+	// 0 keeps the `database/sql` default of 2, a negative value keeps no idle connections.
+	MaxIdleConns int
+	// 0 means connections are reused forever.
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// Limit of every connection attempt, including dialing the server. 0 means no limit.
+	ConnectTimeout time.Duration
+	// How often to try connecting before giving up, i.e. while the database is still starting up. Zero fields fall back to the matching field of `DefaultConnectRetryPolicy`, set `MaxAttempts` to 1 to only try once.
+	Retry RetryPolicy
+	// Only used with `DriverSqlite`, see `SqliteOptions.DSN`.
+	Sqlite SqliteOptions
+	// Logs every failed attempt that is retried at warn level as CONNECT_RETRY, defaults to `Retry.Logger` and then the global zerolog logger.
+	Logger *zerolog.Logger
+}
+
+// Retries for about 20 seconds, on the errors of a database that is not reachable yet, i.e. while it is still starting up. Errors that will not go away by themselves, like a malformed connection string, a wrong password or a missing database, fail right away.
+var DefaultConnectRetryPolicy = RetryPolicy{
+	MaxAttempts:    10,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+	Retryable:      isRetryableConnect,
+}
+
+// Returned by `ConnectWithOptions` when every attempt failed.
+type ConnectError struct {
+	Driver Driver
+	// The error of every attempt, in order.
+	Attempts []error
+}
+
+func (e *ConnectError) Error() string {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "failed to connect to %s after %d attempt(s)", e.Driver, len(e.Attempts))
+	for i, err := range e.Attempts {
+		fmt.Fprintf(&b, "\n\tattempt %d: %v", i+1, err)
+	}
+	return b.String()
+}
+
+func (e *ConnectError) Unwrap() []error {
+	return e.Attempts
+}
+
+// Same as `Connect`, but configures the connection pool, the sqlite pragmas and retries the first connection according to `opts`.
+func ConnectWithOptions(driver Driver, connString string, opts ConnectOptions) (*sqlx.DB, error) {
+	return ConnectWithOptionsContext(context.Background(), driver, connString, opts)
+}
+
+// Stops retrying once `ctx` is done.
+func ConnectWithOptionsContext(ctx context.Context, driver Driver, connString string, opts ConnectOptions) (*sqlx.DB, error) {
+	if driver == DriverSqlite {
+		connString = opts.Sqlite.DSN(connString)
+	}
+	db, err := sqlx.Open(string(driver), connString)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	if opts.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+
+	if opts.Logger != nil {
+		opts.Retry.Logger = opts.Logger
+	}
+	policy := opts.Retry.withDefaultsFrom(DefaultConnectRetryPolicy)
+	connectErr := &ConnectError{Driver: driver}
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := ping(ctx, db, opts.ConnectTimeout)
+		if err == nil {
+			return db, nil
+		}
+		connectErr.Attempts = append(connectErr.Attempts, fmt.Errorf("%w (after %s)", err, time.Since(start).Round(time.Millisecond)))
+		if attempt >= policy.MaxAttempts || !policy.Retryable(err) || ctx.Err() != nil {
+			_ = db.Close()
+			return nil, connectErr
+		}
+
+		backoff := policy.backoff(attempt)
+		policy.Logger.Warn().Err(err).Str("driver", string(driver)).Int("attempt", attempt).Dur("backoff", backoff).Msg("CONNECT_RETRY")
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			_ = db.Close()
+			connectErr.Attempts = append(connectErr.Attempts, ctx.Err())
+			return nil, connectErr
+		case <-timer.C:
+		}
+	}
+}
+
+func ping(ctx context.Context, db *sqlx.DB, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return db.PingContext(ctx)
+}
+
+// Network errors, dropped connections, attempts that ran into `ConnectTimeout` and the postgres errors of a server that is starting up or out of connections are retried, as is a locked sqlite database. Every other error, i.e. a malformed connection string or a wrong password, is not.
+func isRetryableConnect(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// 57P03: cannot_connect_now, 53300: too_many_connections, 08: connection exceptions
+		return pqErr.Code == "57P03" || pqErr.Code == "53300" || pqErr.Code.Class() == "08"
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		// the outer context being done is checked by the caller, so this is the timeout of a single attempt
+		errors.Is(err, context.DeadlineExceeded) ||
+		isRetryableSqlite(err)
+}
+
+// Runs `fn` inside a transaction.
+//
+// The transaction is committed if `fn` returns nil, and rolled back if `fn` returns an error or panics. A panic is re-raised once the transaction has been rolled back.
+//
+// `opts` sets the isolation level and read-only mode, nil uses the driver defaults.
+//
+// The transaction is stored on the context passed to `fn`, so the execute helpers join it when called with that context and `db`, without needing `tx` passed down. See `QuerierFromContext`.
+//
+// If a transaction is already open, either because `db` is a transaction or because `ctx` carries one for `db`, `fn` is run inside a SAVEPOINT instead, so an error only rolls back the work done by `fn` and the outer transaction carries on. `opts` is ignored in that case, as the savepoint inherits the outer transaction's options.
+func WithTx(ctx context.Context, db Querier, opts *sql.TxOptions, fn func(ctx context.Context, tx *sqlx.Tx) error) (err error) {
+	if tx, ok := QuerierFromContext(ctx, db).(*sqlx.Tx); ok {
+		if _, ok := TxFromContext(ctx); !ok {
+			ctx = context.WithValue(ctx, txKey{}, &txState{db: db, tx: tx})
+		}
+		return withSavepoint(ctx, db, tx, fn)
+	}
+
+	c := startTx(ctx, db, &TxEvent{Op: "BEGIN", Opts: opts})
+	tx, done, err := beginTx(c.ctx, db, opts)
+	if err != nil {
+		return c.finish("BEGIN", nil, err)
+	}
+	defer done()
+
+	state := &txState{db: db, tx: tx}
+	openTxs.Store(tx, state)
+	defer openTxs.Delete(tx)
+
+	ctx = context.WithValue(c.ctx, txKey{}, state)
+	return runTx(ctx, tx, fn,
+		func() error {
+			return c.finish("COMMIT", nil, tx.Commit())
+		},
+		func(cause any) error {
+			return c.finish("ROLLBACK", cause, tx.Rollback())
+		},
+	)
+}
+
+type txKey struct{}
+
+type txState struct {
+	db Querier
+	tx *sqlx.Tx
+}
+
+// The state of every transaction opened by `WithTx` until it is committed or rolled back, keyed by its `*sqlx.Tx`, so the helpers called with the `tx` passed to `fn` rather than its context still use the configuration of the `*DB` it was opened on.
+var openTxs sync.Map
+
+// Returns the transaction opened by `WithTx` that `ctx` carries, if any.
+func TxFromContext(ctx context.Context) (*sqlx.Tx, bool) {
+	state, ok := ctx.Value(txKey{}).(*txState)
+	if !ok {
+		return nil, false
+	}
+	return state.tx, true
+}
+
+// Returns the transaction `ctx` carries if it was opened on `db`, otherwise `db` itself.
+//
+// The context-aware execute helpers use this, so they automatically join a transaction opened by `WithTx`.
+func QuerierFromContext(ctx context.Context, db Querier) Querier {
+	state, ok := ctx.Value(txKey{}).(*txState)
+	if !ok || state.db != db {
+		return db
+	}
+	return state.tx
+}
+
+var savepointID atomic.Uint64
+
+func withSavepoint(ctx context.Context, db Querier, tx *sqlx.Tx, fn func(ctx context.Context, tx *sqlx.Tx) error) error {
+	name := fmt.Sprintf("sp_%d", savepointID.Add(1))
+
+	c := startTx(ctx, db, &TxEvent{Op: "SAVEPOINT", Savepoint: name})
+	_, err := tx.ExecContext(c.ctx, "SAVEPOINT "+name)
+	if err != nil {
+		return c.finish("SAVEPOINT", nil, err)
+	}
+
+	return runTx(c.ctx, tx, fn,
+		func() error {
+			_, err := tx.ExecContext(c.ctx, "RELEASE SAVEPOINT "+name)
+			return c.finish("RELEASE SAVEPOINT", nil, err)
+		},
+		func(cause any) error {
+			_, err := tx.ExecContext(context.Background(), "ROLLBACK TO SAVEPOINT "+name)
+			return c.finish("ROLLBACK TO SAVEPOINT", cause, err)
+		},
+	)
+}
+
+// Runs `fn` and then either commits or rolls back, re-raising any panic after the rollback.
+func runTx(ctx context.Context, tx *sqlx.Tx, fn func(ctx context.Context, tx *sqlx.Tx) error, commit func() error, rollback func(cause any) error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			_ = rollback(p)
+			panic(p)
+		}
+	}()
+
+	if err = fn(ctx, tx); err != nil {
+		if rbErr := rollback(err); rbErr != nil {
+			return errors.Join(err, rbErr)
+		}
+		return err
+	}
+
+	return commit()
+}
+
+type txBeginner interface {
+	BeginTxx(ctx context.Context, opts *sql.TxOptions) (*sqlx.Tx, error)
+}
+
+// sqlite ignores `sql.TxOptions.ReadOnly`, so read-only transactions are run on a dedicated connection with `PRAGMA query_only` turned on.
+func beginTx(ctx context.Context, db Querier, opts *sql.TxOptions) (tx *sqlx.Tx, done func(), err error) {
+	if r, ok := db.(router); ok {
+		db = r.route(ctx, true)
+	}
+	sqlxDB, ok := db.(*sqlx.DB)
+	if w, isDB := db.(*DB); isDB {
+		sqlxDB, ok = w.DB, true
+	}
+	if !ok || opts == nil || !opts.ReadOnly || sqlxDB.DriverName() != DriverSqlite {
+		beginner, ok := db.(txBeginner)
+		if !ok {
+			return nil, nil, fmt.Errorf("%T cannot begin a transaction", db)
+		}
+		tx, err = beginner.BeginTxx(ctx, opts)
+		return tx, func() {}, err
+	}
+
+	conn, err := sqlxDB.Connx(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	done = func() {
+		if _, err := conn.ExecContext(context.Background(), "PRAGMA query_only = 0"); err != nil {
+			// never hand a read-only connection back to the pool
+			_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+		_ = conn.Close()
+	}
+
+	if _, err = conn.ExecContext(ctx, "PRAGMA query_only = 1"); err != nil {
+		done()
+		return nil, nil, err
+	}
+	tx, err = conn.BeginTxx(ctx, opts)
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+	return tx, done, nil
+}
@@ -0,0 +1,477 @@
+package boilerplate
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog"
+)
+
+func TestWithTx(t *testing.T) {
+	LoadDB(t)
+
+	type Table struct {
+		ID    int    `db:"id" dbtype:"BIGSERIAL NOT NULL PRIMARY KEY"`
+		Value string `db:"value" dbtype:"TEXT NOT NULL"`
+	}
+	queries := GenerateQueries(GenerateQueriesOptions{
+		TableName:          "table_with_tx",
+		Model:              Table{},
+		AutoGeneratingCols: []string{"id"},
+		PrimaryKeys:        []string{"id"},
+		Driver:             DriverSqlite,
+	})
+
+	t.Cleanup(func() {
+		_ = Exec(db, queries.DropTable)
+	})
+
+	err := Exec(db, queries.CreateTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(t *testing.T, value string) int {
+		n, err := Get[int](db, "SELECT COUNT(*) FROM table_with_tx WHERE value = $1", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		err := WithTx(context.Background(), db, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+			row := Table{Value: "commit"}
+			return NamedExecReturning(tx, &row, queries.Insert, &row)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := count(t, "commit"); n != 1 {
+			t.Fatalf("Expected committed row to exist, got %d rows", n)
+		}
+	})
+
+	t.Run("rollback on error", func(t *testing.T) {
+		errExpected := errors.New("expected")
+		err := WithTx(context.Background(), db, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+			row := Table{Value: "error"}
+			if err := NamedExecReturning(tx, &row, queries.Insert, &row); err != nil {
+				return err
+			}
+			return errExpected
+		})
+		if !errors.Is(err, errExpected) {
+			t.Fatalf("Expected error from fn to be returned, got: %v", err)
+		}
+		if n := count(t, "error"); n != 0 {
+			t.Fatalf("Expected rolled back row to be gone, got %d rows", n)
+		}
+	})
+
+	t.Run("rollback on panic", func(t *testing.T) {
+		func() {
+			defer func() {
+				if p := recover(); p != "expected" {
+					t.Fatalf("Expected panic to be re-raised, got: %v", p)
+				}
+			}()
+			_ = WithTx(context.Background(), db, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+				row := Table{Value: "panic"}
+				if err := NamedExecReturning(tx, &row, queries.Insert, &row); err != nil {
+					return err
+				}
+				panic("expected")
+			})
+		}()
+		if n := count(t, "panic"); n != 0 {
+			t.Fatalf("Expected rolled back row to be gone, got %d rows", n)
+		}
+	})
+
+	t.Run("isolation level", func(t *testing.T) {
+		err := WithTx(context.Background(), db, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(ctx context.Context, tx *sqlx.Tx) error {
+			row := Table{Value: "serializable"}
+			return NamedExecReturning(tx, &row, queries.Insert, &row)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := count(t, "serializable"); n != 1 {
+			t.Fatalf("Expected committed row to exist, got %d rows", n)
+		}
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		err := WithTx(context.Background(), db, &sql.TxOptions{ReadOnly: true}, func(ctx context.Context, tx *sqlx.Tx) error {
+			if _, err := Get[int](tx, "SELECT COUNT(*) FROM table_with_tx"); err != nil {
+				return err
+			}
+			row := Table{Value: "read only"}
+			return NamedExecReturning(tx, &row, queries.Insert, &row)
+		})
+		if err == nil {
+			t.Fatal("Expected write in a read-only transaction to fail, but got nil.")
+		}
+		if n := count(t, "read only"); n != 0 {
+			t.Fatalf("Expected no row to be written, got %d rows", n)
+		}
+
+		// the connection must be writable again once the read-only transaction is done
+		err = Exec(db, "INSERT INTO table_with_tx (value) VALUES ($1)", "after read only")
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestWithTxNested(t *testing.T) {
+	LoadDB(t)
+
+	type Table struct {
+		ID    int    `db:"id" dbtype:"BIGSERIAL NOT NULL PRIMARY KEY"`
+		Value string `db:"value" dbtype:"TEXT NOT NULL"`
+	}
+	queries := GenerateQueries(GenerateQueriesOptions{
+		TableName:          "table_with_tx_nested",
+		Model:              Table{},
+		AutoGeneratingCols: []string{"id"},
+		PrimaryKeys:        []string{"id"},
+		Driver:             DriverSqlite,
+	})
+
+	t.Cleanup(func() {
+		_ = Exec(db, queries.DropTable)
+	})
+
+	err := Exec(db, queries.CreateTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insert := func(tx *sqlx.Tx, value string) error {
+		row := Table{Value: value}
+		return NamedExecReturning(tx, &row, queries.Insert, &row)
+	}
+	values := func(t *testing.T) []string {
+		v, err := Select[[]string](db, "SELECT value FROM table_with_tx_nested ORDER BY id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	errExpected := errors.New("expected")
+
+	t.Run("inner error only rolls back inner", func(t *testing.T) {
+		t.Cleanup(func() { _ = Exec(db, "DELETE FROM table_with_tx_nested") })
+
+		err := WithTx(context.Background(), db, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+			if err := insert(tx, "outer"); err != nil {
+				return err
+			}
+			err := WithTx(context.Background(), tx, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+				if err := insert(tx, "inner"); err != nil {
+					return err
+				}
+				return errExpected
+			})
+			if !errors.Is(err, errExpected) {
+				t.Fatalf("Expected inner error to be returned, got: %v", err)
+			}
+			return insert(tx, "outer after inner")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertStructEqual(t, []string{"outer", "outer after inner"}, values(t), "Expected only the inner savepoint to be rolled back")
+	})
+
+	t.Run("inner panic only rolls back inner", func(t *testing.T) {
+		t.Cleanup(func() { _ = Exec(db, "DELETE FROM table_with_tx_nested") })
+
+		err := WithTx(context.Background(), db, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+			if err := insert(tx, "outer"); err != nil {
+				return err
+			}
+			func() {
+				defer func() { _ = recover() }()
+				_ = WithTx(context.Background(), tx, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+					if err := insert(tx, "inner"); err != nil {
+						return err
+					}
+					panic("expected")
+				})
+			}()
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertStructEqual(t, []string{"outer"}, values(t), "Expected only the inner savepoint to be rolled back")
+	})
+
+	t.Run("outer error rolls back released inner", func(t *testing.T) {
+		t.Cleanup(func() { _ = Exec(db, "DELETE FROM table_with_tx_nested") })
+
+		err := WithTx(context.Background(), db, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+			if err := insert(tx, "outer"); err != nil {
+				return err
+			}
+			err := WithTx(context.Background(), tx, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+				return insert(tx, "inner")
+			})
+			if err != nil {
+				return err
+			}
+			return errExpected
+		})
+		if !errors.Is(err, errExpected) {
+			t.Fatalf("Expected outer error to be returned, got: %v", err)
+		}
+		AssertStructEqual(t, []string(nil), values(t), "Expected everything to be rolled back")
+	})
+
+	t.Run("deeply nested", func(t *testing.T) {
+		t.Cleanup(func() { _ = Exec(db, "DELETE FROM table_with_tx_nested") })
+
+		err := WithTx(context.Background(), db, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+			return WithTx(context.Background(), tx, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+				if err := insert(tx, "level 2"); err != nil {
+					return err
+				}
+				_ = WithTx(context.Background(), tx, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+					if err := insert(tx, "level 3"); err != nil {
+						return err
+					}
+					return errExpected
+				})
+				return WithTx(context.Background(), tx, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+					return insert(tx, "level 3 again")
+				})
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertStructEqual(t, []string{"level 2", "level 3 again"}, values(t), "Expected only the failed savepoint to be rolled back")
+	})
+}
+
+func TestWithTxContext(t *testing.T) {
+	LoadDB(t)
+
+	type Table struct {
+		ID    int    `db:"id" dbtype:"BIGSERIAL NOT NULL PRIMARY KEY"`
+		Value string `db:"value" dbtype:"TEXT NOT NULL"`
+	}
+	queries := GenerateQueries(GenerateQueriesOptions{
+		TableName:          "table_with_tx_context",
+		Model:              Table{},
+		AutoGeneratingCols: []string{"id"},
+		PrimaryKeys:        []string{"id"},
+		Driver:             DriverSqlite,
+	})
+
+	t.Cleanup(func() {
+		_ = Exec(db, queries.DropTable)
+	})
+
+	err := Exec(db, queries.CreateTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := func(t *testing.T) []string {
+		v, err := Select[[]string](db, "SELECT value FROM table_with_tx_context ORDER BY id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	errExpected := errors.New("expected")
+
+	t.Run("accessors", func(t *testing.T) {
+		if _, ok := TxFromContext(context.Background()); ok {
+			t.Fatal("Expected no transaction on an empty context.")
+		}
+		if q := QuerierFromContext(context.Background(), db); q != db {
+			t.Fatalf("Expected QuerierFromContext to fall back to db, got %T", q)
+		}
+
+		err := WithTx(context.Background(), db, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+			if got, ok := TxFromContext(ctx); !ok || got != tx {
+				t.Fatal("Expected the context to carry the transaction.")
+			}
+			if q := QuerierFromContext(ctx, db); q != tx {
+				t.Fatalf("Expected QuerierFromContext to return the transaction, got %T", q)
+			}
+
+			other, err := Connect(DriverSqlite, ":memory:")
+			if err != nil {
+				return err
+			}
+			defer func() { _ = other.Close() }()
+			if q := QuerierFromContext(ctx, other); q != other {
+				t.Fatalf("Expected a different db not to join the transaction, got %T", q)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("helpers join the transaction", func(t *testing.T) {
+		t.Cleanup(func() { _ = Exec(db, "DELETE FROM table_with_tx_context") })
+
+		err := WithTx(context.Background(), db, nil, func(ctx context.Context, _ *sqlx.Tx) error {
+			row := Table{Value: "named"}
+			if err := NamedExecReturningContext(ctx, db, &row, queries.Insert, &row); err != nil {
+				return err
+			}
+			if err := ExecContext(ctx, db, "INSERT INTO table_with_tx_context (value) VALUES ($1)", "exec"); err != nil {
+				return err
+			}
+			n, err := GetContext[int](ctx, db, "SELECT COUNT(*) FROM table_with_tx_context")
+			if err != nil {
+				return err
+			}
+			if n != 2 {
+				t.Fatalf("Expected 2 rows to be visible inside the transaction, got %d", n)
+			}
+			return errExpected
+		})
+		if !errors.Is(err, errExpected) {
+			t.Fatalf("Expected error from fn to be returned, got: %v", err)
+		}
+		AssertStructEqual(t, []string(nil), values(t), "Expected writes made through the context to be rolled back")
+	})
+
+	t.Run("nested through the context", func(t *testing.T) {
+		t.Cleanup(func() { _ = Exec(db, "DELETE FROM table_with_tx_context") })
+
+		err := WithTx(context.Background(), db, nil, func(ctx context.Context, _ *sqlx.Tx) error {
+			if err := ExecContext(ctx, db, "INSERT INTO table_with_tx_context (value) VALUES ($1)", "outer"); err != nil {
+				return err
+			}
+			_ = WithTx(ctx, db, nil, func(ctx context.Context, _ *sqlx.Tx) error {
+				if err := ExecContext(ctx, db, "INSERT INTO table_with_tx_context (value) VALUES ($1)", "inner"); err != nil {
+					return err
+				}
+				return errExpected
+			})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertStructEqual(t, []string{"outer"}, values(t), "Expected only the inner savepoint to be rolled back")
+	})
+}
+
+func TestConnectWithOptions(t *testing.T) {
+	t.Run("pool", func(t *testing.T) {
+		db, err := ConnectWithOptions(DriverSqlite, filepath.Join(t.TempDir(), "pool.db"), ConnectOptions{
+			MaxOpenConns:    3,
+			ConnMaxLifetime: time.Minute,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+		if n := db.Stats().MaxOpenConnections; n != 3 {
+			t.Fatalf("Expected 3 max open connections, got %d", n)
+		}
+	})
+
+	t.Run("retries until the database is available", func(t *testing.T) {
+		// sqlite cannot open a file in a directory that does not exist yet
+		dir := filepath.Join(t.TempDir(), "later")
+		attempts := 0
+		db, err := ConnectWithOptions(DriverSqlite, filepath.Join(dir, "retry.db"), ConnectOptions{
+			Retry: RetryPolicy{
+				MaxAttempts:    5,
+				InitialBackoff: time.Millisecond,
+				Retryable: func(err error) bool {
+					attempts++
+					if attempts == 2 {
+						_ = os.Mkdir(dir, 0o755)
+					}
+					return true
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+		if attempts != 2 {
+			t.Fatalf("Expected to connect on the third attempt, got %d failed attempts", attempts)
+		}
+	})
+
+	t.Run("lists every failed attempt", func(t *testing.T) {
+		// nothing listens on port 1, so every attempt is refused
+		buf := bytes.Buffer{}
+		logger := zerolog.New(&buf)
+		_, err := ConnectWithOptions(DriverPostgres, "postgres://user@127.0.0.1:1/db?sslmode=disable", ConnectOptions{
+			ConnectTimeout: time.Second,
+			Retry:          RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			Logger:         &logger,
+		})
+		var connectErr *ConnectError
+		if !errors.As(err, &connectErr) {
+			t.Fatalf("Expected a ConnectError, got: %v", err)
+		}
+		if len(connectErr.Attempts) != 3 {
+			t.Fatalf("Expected 3 attempts, got %d: %v", len(connectErr.Attempts), err)
+		}
+		if !strings.Contains(err.Error(), "attempt 3: ") {
+			t.Fatalf("Expected the error to list every attempt, got: %v", err)
+		}
+		if n := strings.Count(buf.String(), "CONNECT_RETRY"); n != 2 {
+			t.Fatalf("Expected the 2 retries to be logged to the logger, got %d:\n%s", n, buf.String())
+		}
+	})
+
+	t.Run("does not retry permanent errors", func(t *testing.T) {
+		_, err := ConnectWithOptions(DriverSqlite, filepath.Join(t.TempDir(), "missing", "db.db"), ConnectOptions{
+			Retry: RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+		})
+		var connectErr *ConnectError
+		if !errors.As(err, &connectErr) || len(connectErr.Attempts) != 1 {
+			t.Fatalf("Expected a single failed attempt, got: %v", err)
+		}
+	})
+
+	t.Run("does not retry a malformed connection string", func(t *testing.T) {
+		start := time.Now()
+		_, err := ConnectWithOptions(DriverPostgres, "host=localhost port=5432 dbname", ConnectOptions{})
+		var connectErr *ConnectError
+		if !errors.As(err, &connectErr) || len(connectErr.Attempts) != 1 {
+			t.Fatalf("Expected a single failed attempt, got: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("Expected to give up right away, took %s", elapsed)
+		}
+	})
+
+	t.Run("stops when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := ConnectWithOptionsContext(ctx, DriverPostgres, "postgres://user@127.0.0.1:1/db?sslmode=disable", ConnectOptions{
+			Retry: RetryPolicy{MaxAttempts: 1000, InitialBackoff: 10 * time.Millisecond},
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected the deadline to end the retries, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package boilerplate
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog"
+)
+
+// Wraps a `*sqlx.DB` to configure how the execute helpers run on it.
+//
+// It can be passed anywhere a `Querier` is expected. Transactions opened on it with `WithTx` use the same configuration.
+type DB struct {
+	*sqlx.DB
+	hooks []QueryHook
+	stmts *stmtCache
+}
+
+type DBOptions struct {
+	// Run around every query, BeforeQuery in order and AfterQuery in reverse order. Hooks that also implement `TxHook` are run around transactions too.
+	//
+	// nil uses a `LogHook` with `Logger`. Include a `LogHook` yourself to keep the trace logs alongside other hooks, or pass an empty slice to run no hooks at all.
+	Hooks []QueryHook
+	// The logger of the default `LogHook`, defaults to the global zerolog logger.
+	Logger *zerolog.Logger
+	// Passed on to the default `LogHook`, see the fields of the same name on `LogHook`.
+	SlowQueryThreshold time.Duration
+	ExplainSlowQueries bool
+	RedactColumns      []string
+	MaxResultSize      int
+	// Number of prepared statements to keep, least recently used ones are closed first. 0 disables the cache.
+	//
+	// With the cache enabled, the queries of the execute helpers are prepared once and reused, rather than parsed by the database on every call. Queries inside a transaction are not cached.
+	//
+	// On postgres, statements are prepared on the server, so the cache saves parsing and planning the query on every call. This matters most for complex queries on a busy server, measure it with `BenchmarkStatementCache` and BOILERPLATE_POSTGRES_DSN before turning it on.
+	//
+	// modernc's sqlite driver prepares the query again on every call either way, so there is nothing to gain on sqlite: the benchmark shows no difference between cached and uncached queries.
+	StatementCacheSize int
+}
+
+func NewDB(db *sqlx.DB, opts DBOptions) *DB {
+	hooks := opts.Hooks
+	if hooks == nil {
+		hooks = []QueryHook{LogHook{
+			Logger:             opts.Logger,
+			SlowQueryThreshold: opts.SlowQueryThreshold,
+			ExplainSlowQueries: opts.ExplainSlowQueries,
+			RedactColumns:      opts.RedactColumns,
+			MaxResultSize:      opts.MaxResultSize,
+		}}
+	}
+	w := &DB{DB: db, hooks: hooks}
+	if opts.StatementCacheSize > 0 {
+		w.stmts = newStmtCache(db, opts.StatementCacheSize)
+	}
+	return w
+}
+
+// Closes the cached statements, see `DBOptions.StatementCacheSize`. They are prepared again when next used, i.e. after a migration changed the tables they use.
+func (db *DB) ResetStatementCache() {
+	if db.stmts != nil {
+		db.stmts.reset()
+	}
+}
+
+// Closes the cached statements and the database.
+func (db *DB) Close() error {
+	db.ResetStatementCache()
+	return db.DB.Close()
+}
+
+// The methods below are used by the execute helpers, and run on a cached statement when the statement cache is enabled.
+
+func (db *DB) GetContext(ctx context.Context, dest any, query string, args ...any) error {
+	if db.stmts == nil {
+		return db.DB.GetContext(ctx, dest, query, args...)
+	}
+	_, err := withStmt(ctx, db.stmts, query, func(stmt *sqlx.Stmt) (any, error) {
+		return nil, stmt.GetContext(ctx, dest, args...)
+	})
+	return err
+}
+
+func (db *DB) SelectContext(ctx context.Context, dest any, query string, args ...any) error {
+	if db.stmts == nil {
+		return db.DB.SelectContext(ctx, dest, query, args...)
+	}
+	_, err := withStmt(ctx, db.stmts, query, func(stmt *sqlx.Stmt) (any, error) {
+		return nil, stmt.SelectContext(ctx, dest, args...)
+	})
+	return err
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if db.stmts == nil {
+		return db.DB.ExecContext(ctx, query, args...)
+	}
+	return withStmt(ctx, db.stmts, query, func(stmt *sqlx.Stmt) (sql.Result, error) {
+		return stmt.ExecContext(ctx, args...)
+	})
+}
+
+func (db *DB) QueryxContext(ctx context.Context, query string, args ...any) (*sqlx.Rows, error) {
+	if db.stmts == nil {
+		return db.DB.QueryxContext(ctx, query, args...)
+	}
+	return withStmt(ctx, db.stmts, query, func(stmt *sqlx.Stmt) (*sqlx.Rows, error) {
+		return stmt.QueryxContext(ctx, args...)
+	})
+}
@@ -0,0 +1,146 @@
+package boilerplate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Errors returned by the execute helpers, these can be checked with `errors.Is` regardless of the driver.
+//
+// Constraint violations are returned as a `*ConstraintError`, which also carries the table, column and constraint name when the driver reports them.
+var (
+	ErrUniqueViolation     = errors.New("unique constraint violation")
+	ErrForeignKeyViolation = errors.New("foreign key constraint violation")
+	ErrNotNullViolation    = errors.New("not null constraint violation")
+	ErrCheckViolation      = errors.New("check constraint violation")
+	// The same error as `sql.ErrNoRows`, so existing checks against either keep working.
+	ErrNoRows = sql.ErrNoRows
+	// Returned as a `*RowsAffectedError` by `ExecExpect` and `NamedExecExpect`.
+	ErrUnexpectedRowsAffected = errors.New("unexpected number of rows affected")
+)
+
+type ConstraintError struct {
+	// One of `ErrUniqueViolation`, `ErrForeignKeyViolation`, `ErrNotNullViolation` or `ErrCheckViolation`.
+	Kind error
+	// Table, Column and Constraint are empty when the driver does not report them. sqlite only reports the table and columns for UNIQUE and NOT NULL, and the constraint name (or expression) for CHECK. Multiple columns are separated by ", ".
+	Table      string
+	Column     string
+	Constraint string
+	// The original driver error, i.e. a `*pq.Error` or `*sqlite.Error`.
+	Err error
+}
+
+func (e *ConstraintError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConstraintError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+type RowsAffectedError struct {
+	Expected int64
+	// -1 when the driver does not report the rows affected.
+	Actual int64
+}
+
+func (e *RowsAffectedError) Error() string {
+	return fmt.Sprintf("%s: expected %d, got %d", ErrUnexpectedRowsAffected, e.Expected, e.Actual)
+}
+
+// Also matches `ErrNoRows` when no rows were affected.
+func (e *RowsAffectedError) Unwrap() []error {
+	if e.Actual == 0 {
+		return []error{ErrUnexpectedRowsAffected, ErrNoRows}
+	}
+	return []error{ErrUnexpectedRowsAffected}
+}
+
+// Reports whether `err`, returned by an execute helper, means the query failed. `ErrNoRows` is a result rather than a failure of the database, so it is not one. `MetricsHook` and the otelboilerplate hook use this to decide what counts as an error.
+func IsQueryFailure(err error) bool {
+	return err != nil && !errors.Is(err, ErrNoRows)
+}
+
+// Maps driver errors to the errors above, any other error is returned as is.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var constraintErr *ConstraintError
+	if errors.As(err, &constraintErr) {
+		return err
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		kind := postgresConstraintKinds[pqErr.Code]
+		if kind == nil {
+			return err
+		}
+		return &ConstraintError{
+			Kind:       kind,
+			Table:      pqErr.Table,
+			Column:     pqErr.Column,
+			Constraint: pqErr.Constraint,
+			Err:        err,
+		}
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		kind := sqliteConstraintKinds[sqliteErr.Code()]
+		if kind == nil {
+			return err
+		}
+		constraintErr := &ConstraintError{Kind: kind, Err: err}
+
+		// sqlite only reports the details in the message, i.e. "UNIQUE constraint failed: t.a, t.b (2067)"
+		detail := ""
+		if m := sqliteConstraintDetail.FindStringSubmatch(sqliteErr.Error()); m != nil {
+			detail = m[1]
+		}
+		switch kind {
+		case ErrUniqueViolation, ErrNotNullViolation:
+			cols := []string{}
+			for col := range strings.SplitSeq(detail, ", ") {
+				table, name, ok := strings.Cut(col, ".")
+				if !ok {
+					continue
+				}
+				constraintErr.Table = table
+				cols = append(cols, name)
+			}
+			constraintErr.Column = strings.Join(cols, ", ")
+		case ErrCheckViolation:
+			constraintErr.Constraint = detail
+		}
+		return constraintErr
+	}
+
+	return err
+}
+
+var postgresConstraintKinds = map[pq.ErrorCode]error{
+	"23505": ErrUniqueViolation,
+	"23503": ErrForeignKeyViolation,
+	"23502": ErrNotNullViolation,
+	"23514": ErrCheckViolation,
+}
+
+var sqliteConstraintKinds = map[int]error{
+	sqlite3.SQLITE_CONSTRAINT_UNIQUE:     ErrUniqueViolation,
+	sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY: ErrUniqueViolation,
+	sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY: ErrForeignKeyViolation,
+	sqlite3.SQLITE_CONSTRAINT_NOTNULL:    ErrNotNullViolation,
+	sqlite3.SQLITE_CONSTRAINT_CHECK:      ErrCheckViolation,
+}
+
+var sqliteConstraintDetail = regexp.MustCompile(`(?:UNIQUE|NOT NULL|CHECK) constraint failed: (.+) \(\d+\)$`)
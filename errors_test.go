@@ -0,0 +1,188 @@
+package boilerplate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+)
+
+func TestIsQueryFailure(t *testing.T) {
+	if IsQueryFailure(nil) || IsQueryFailure(ErrNoRows) || IsQueryFailure(fmt.Errorf("get: %w", sql.ErrNoRows)) {
+		t.Fatal("Expected nil and no rows not to be failures")
+	}
+	if !IsQueryFailure(errors.New("syntax error")) || !IsQueryFailure(&ConstraintError{Kind: ErrUniqueViolation, Err: errors.New("unique")}) {
+		t.Fatal("Expected other errors to be failures")
+	}
+}
+
+func TestConstraintErrors(t *testing.T) {
+	// foreign keys are enabled per connection, so use a separate single connection db
+	db, err := Connect(DriverSqlite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	type Parent struct {
+		ID    int    `db:"id"`
+		Name  string `db:"name"`
+		Code  string `db:"code"`
+		Count int    `db:"count"`
+	}
+
+	for _, query := range []string{
+		"PRAGMA foreign_keys = ON",
+		"CREATE TABLE parent (id INTEGER PRIMARY KEY, name TEXT NOT NULL UNIQUE, code TEXT NOT NULL DEFAULT '', count INT NOT NULL DEFAULT 1 CONSTRAINT count_positive CHECK (count > 0), UNIQUE (code, count))",
+		"CREATE TABLE child (id INTEGER PRIMARY KEY, parent_id INT NOT NULL REFERENCES parent (id))",
+		"INSERT INTO parent (id, name, code) VALUES (1, 'existing', 'a')",
+	} {
+		if err := Exec(db, query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		run      func() error
+		expected ConstraintError
+	}{
+		{
+			name: "unique",
+			run: func() error {
+				return Exec(db, "INSERT INTO parent (name, code) VALUES ($1, $2)", "existing", "b")
+			},
+			expected: ConstraintError{Kind: ErrUniqueViolation, Table: "parent", Column: "name"},
+		},
+		{
+			name: "unique multiple columns",
+			run: func() error {
+				return Exec(db, "INSERT INTO parent (name, code) VALUES ($1, $2)", "new", "a")
+			},
+			expected: ConstraintError{Kind: ErrUniqueViolation, Table: "parent", Column: "code, count"},
+		},
+		{
+			name: "primary key",
+			run: func() error {
+				return Exec(db, "INSERT INTO parent (id, name) VALUES ($1, $2)", 1, "new")
+			},
+			expected: ConstraintError{Kind: ErrUniqueViolation, Table: "parent", Column: "id"},
+		},
+		{
+			name: "not null",
+			run: func() error {
+				return Exec(db, "INSERT INTO parent (name) VALUES (NULL)")
+			},
+			expected: ConstraintError{Kind: ErrNotNullViolation, Table: "parent", Column: "name"},
+		},
+		{
+			name: "check",
+			run: func() error {
+				return Exec(db, "INSERT INTO parent (name, count) VALUES ($1, $2)", "new", -1)
+			},
+			expected: ConstraintError{Kind: ErrCheckViolation, Constraint: "count_positive"},
+		},
+		{
+			name: "foreign key",
+			run: func() error {
+				return Exec(db, "INSERT INTO child (parent_id) VALUES ($1)", 99)
+			},
+			expected: ConstraintError{Kind: ErrForeignKeyViolation},
+		},
+		{
+			name: "named exec returning",
+			run: func() error {
+				row := Parent{Name: "existing", Code: "c", Count: 1}
+				return NamedExecReturning(db, &row, "INSERT INTO parent (name, code, count) VALUES (:name, :code, :count) RETURNING *", &row)
+			},
+			expected: ConstraintError{Kind: ErrUniqueViolation, Table: "parent", Column: "name"},
+		},
+		{
+			name: "named exec",
+			run: func() error {
+				return NamedExec(db, nil, "INSERT INTO parent (name, code, count) VALUES (:name, :code, :count)", Parent{Name: "new", Code: "d", Count: 0})
+			},
+			expected: ConstraintError{Kind: ErrCheckViolation, Constraint: "count_positive"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.run()
+			if !errors.Is(err, test.expected.Kind) {
+				t.Fatalf("Expected errors.Is(err, %v), got: %v", test.expected.Kind, err)
+			}
+			var constraintErr *ConstraintError
+			if !errors.As(err, &constraintErr) {
+				t.Fatalf("Expected a *ConstraintError, got: %T", err)
+			}
+			if constraintErr.Table != test.expected.Table || constraintErr.Column != test.expected.Column || constraintErr.Constraint != test.expected.Constraint {
+				t.Fatalf("Expected table %q, column %q and constraint %q, got table %q, column %q and constraint %q",
+					test.expected.Table, test.expected.Column, test.expected.Constraint,
+					constraintErr.Table, constraintErr.Column, constraintErr.Constraint)
+			}
+			var sqliteErr *sqlite.Error
+			if !errors.As(err, &sqliteErr) {
+				t.Fatalf("Expected the driver error to still be available, got: %T", err)
+			}
+		})
+	}
+
+	t.Run("no rows", func(t *testing.T) {
+		_, err := Get[Parent](db, "SELECT * FROM parent WHERE id = $1", 99)
+		if !errors.Is(err, ErrNoRows) || !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("Expected ErrNoRows, got: %v", err)
+		}
+		err = NamedExecReturning(db, &Parent{}, "UPDATE parent SET name = :name WHERE id = :id RETURNING *", &Parent{ID: 99, Name: "missing"})
+		if !errors.Is(err, ErrNoRows) {
+			t.Fatalf("Expected ErrNoRows, got: %v", err)
+		}
+	})
+
+	t.Run("other errors are untouched", func(t *testing.T) {
+		err := Exec(db, "INSERT INTO does_not_exist (id) VALUES (1)")
+		var constraintErr *ConstraintError
+		if err == nil || errors.As(err, &constraintErr) {
+			t.Fatalf("Expected a plain error, got: %v", err)
+		}
+	})
+}
+
+func TestConstraintErrorsPostgres(t *testing.T) {
+	tests := []struct {
+		err      *pq.Error
+		expected error
+	}{
+		{err: &pq.Error{Code: "23505", Table: "t", Column: "c", Constraint: "t_c_key"}, expected: ErrUniqueViolation},
+		{err: &pq.Error{Code: "23503", Table: "t", Column: "c", Constraint: "t_c_fkey"}, expected: ErrForeignKeyViolation},
+		{err: &pq.Error{Code: "23502", Table: "t", Column: "c"}, expected: ErrNotNullViolation},
+		{err: &pq.Error{Code: "23514", Table: "t", Constraint: "t_c_check"}, expected: ErrCheckViolation},
+	}
+	for _, test := range tests {
+		err := classifyError(test.err)
+		if !errors.Is(err, test.expected) {
+			t.Fatalf("Expected code %s to map to %v, got: %v", test.err.Code, test.expected, err)
+		}
+		var constraintErr *ConstraintError
+		if !errors.As(err, &constraintErr) {
+			t.Fatalf("Expected a *ConstraintError, got: %T", err)
+		}
+		if constraintErr.Table != test.err.Table || constraintErr.Column != test.err.Column || constraintErr.Constraint != test.err.Constraint {
+			t.Fatalf("Expected the details of %+v, got %+v", test.err, constraintErr)
+		}
+		var pqErr *pq.Error
+		if !errors.As(err, &pqErr) || pqErr != test.err {
+			t.Fatal("Expected the *pq.Error to still be available.")
+		}
+	}
+
+	err := classifyError(&pq.Error{Code: "42P01"})
+	var constraintErr *ConstraintError
+	if errors.As(err, &constraintErr) {
+		t.Fatalf("Expected undefined_table not to be a constraint error, got: %v", err)
+	}
+}
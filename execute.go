@@ -1,51 +1,439 @@
 package boilerplate
 
 import (
+	"context"
 	"database/sql"
+	"iter"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
-	"github.com/rs/zerolog/log"
 )
 
-func Get[T any](db *sqlx.DB, query string, args ...any) (t T, err error) {
-	err = db.Get(&t, query, args...)
-	log.Trace().Err(err).Any("result", t).Str("_query", query).Any("args", args).Msg("GET")
+// Querier is implemented by *sqlx.DB, *sqlx.Tx and *sqlx.Conn, so the same helpers can run inside or outside a transaction.
+type Querier interface {
+	sqlx.QueryerContext
+	sqlx.ExecerContext
+	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+	Rebind(query string) string
+}
+
+var (
+	_ Querier = (*sqlx.DB)(nil)
+	_ Querier = (*sqlx.Tx)(nil)
+	_ Querier = (*sqlx.Conn)(nil)
+)
+
+// The positional helpers accept `?` placeholders for every driver, and rebind them for the driver `db` was opened with, so `WHERE id = ?` runs as `WHERE id = $1` on postgres. Write `??` for a literal question mark, i.e. the jsonb `?` operator. Queries written with `$1` placeholders, and queries without args, are run as is.
+//
+// Slice args are expanded into one placeholder per element for both placeholder styles, so `WHERE id IN (?)` works with a `[]int64`. An empty slice matches nothing. See `expandIn`.
+func Get[T any](db Querier, query string, args ...any) (t T, err error) {
+	return GetContext[T](context.Background(), db, query, args...)
+}
+
+func GetContext[T any](ctx context.Context, db Querier, query string, args ...any) (t T, err error) {
+	c := startQuery(ctx, db, "GET", query, args)
+	err = c.err
+	if err == nil {
+		err = c.q.GetContext(c.ctx, &t, c.query, c.args...)
+	}
+	err = c.finish(t, rowCount(err == nil), err)
 	return
 }
 
-func Select[T any](db *sqlx.DB, query string, args ...any) (t T, err error) {
-	err = db.Select(&t, query, args...)
-	log.Trace().Err(err).Any("result", t).Str("_query", query).Any("args", args).Msg("SELECT")
+func Select[T any](db Querier, query string, args ...any) (t T, err error) {
+	return SelectContext[T](context.Background(), db, query, args...)
+}
+
+func SelectContext[T any](ctx context.Context, db Querier, query string, args ...any) (t T, err error) {
+	c := startQuery(ctx, db, "SELECT", query, args)
+	err = c.err
+	if err == nil {
+		err = c.q.SelectContext(c.ctx, &t, c.query, c.args...)
+	}
+	err = c.finish(t, resultLen(t), err)
 	return
 }
 
-func Exec(db *sqlx.DB, query string, args ...any) (err error) {
-	r, err := db.Exec(query, args...)
-	log.Trace().Err(err).Any("result", r).Str("_query", query).Any("args", args).Msg("EXEC")
+// Like `Get`, but with named args, given as a struct or a `map[string]any`:
+//
+//	NamedGet[Table](db, queries.Select+" WHERE id = :id", map[string]any{"id": id})
+func NamedGet[T any](db Querier, query string, arg any) (t T, err error) {
+	return NamedGetContext[T](context.Background(), db, query, arg)
+}
+
+func NamedGetContext[T any](ctx context.Context, db Querier, query string, arg any) (t T, err error) {
+	c := startQuery(ctx, db, "NAMED_GET", query, []any{arg})
+	boundQuery, boundArgs, err := bindNamed(c.q, query, arg)
+	if err == nil {
+		err = c.q.GetContext(c.ctx, &t, boundQuery, boundArgs...)
+	}
+	err = c.finish(t, rowCount(err == nil), err)
 	return
 }
 
-func NamedExecReturning(db *sqlx.DB, dest any, query string, args ...any) error {
-	rows, err := db.NamedQuery(query, args)
-	log.Trace().Err(err).Any("result", rows).Str("_query", query).Any("args", args).Msg("NAMED_EXEC_RET")
+// Like `Select`, but with named args, given as a struct or a `map[string]any`:
+//
+//	NamedSelect[[]Table](db, queries.Select+" WHERE owner_id = :owner_id", Table{OwnerID: ownerID})
+func NamedSelect[T any](db Querier, query string, arg any) (t T, err error) {
+	return NamedSelectContext[T](context.Background(), db, query, arg)
+}
+
+func NamedSelectContext[T any](ctx context.Context, db Querier, query string, arg any) (t T, err error) {
+	c := startQuery(ctx, db, "NAMED_SELECT", query, []any{arg})
+	boundQuery, boundArgs, err := bindNamed(c.q, query, arg)
+	if err == nil {
+		err = c.q.SelectContext(c.ctx, &t, boundQuery, boundArgs...)
+	}
+	err = c.finish(t, resultLen(t), err)
+	return
+}
+
+func Exec(db Querier, query string, args ...any) (err error) {
+	return ExecContext(context.Background(), db, query, args...)
+}
+
+func ExecContext(ctx context.Context, db Querier, query string, args ...any) (err error) {
+	_, err = ExecResultContext(ctx, db, query, args...)
+	return
+}
+
+// Like `Exec`, but returns the `sql.Result` for its `RowsAffected` and `LastInsertId`. postgres does not support `LastInsertId`, use `RETURNING` with `NamedExecReturning` instead.
+func ExecResult(db Querier, query string, args ...any) (sql.Result, error) {
+	return ExecResultContext(context.Background(), db, query, args...)
+}
+
+func ExecResultContext(ctx context.Context, db Querier, query string, args ...any) (sql.Result, error) {
+	return execContext(ctx, db, "EXEC", query, args, -1)
+}
+
+// Like `Exec`, but returns a `*RowsAffectedError` unless exactly `n` rows were affected, i.e. for an UPDATE or DELETE by primary key that should touch one row.
+//
+// The statement is not rolled back when the check fails, run it in `WithTx` to undo it.
+func ExecExpect(db Querier, n int64, query string, args ...any) (sql.Result, error) {
+	return ExecExpectContext(context.Background(), db, n, query, args...)
+}
+
+func ExecExpectContext(ctx context.Context, db Querier, n int64, query string, args ...any) (sql.Result, error) {
+	return execContext(ctx, db, "EXEC", query, args, n)
+}
 
+func NamedExecReturning(db Querier, dest any, query string, args ...any) error {
+	return NamedExecReturningContext(context.Background(), db, dest, query, args...)
+}
+
+func NamedExecReturningContext(ctx context.Context, db Querier, dest any, query string, args ...any) error {
+	c := startQuery(ctx, db, "NAMED_EXEC_RET", query, args)
+	rows, err := namedQuery(c.ctx, c.q, query, namedArg(args))
 	if err != nil {
-		return err
+		return c.finish(nil, -1, err)
 	}
-	defer func() { _ = rows.Close() }()
 
-	if rows.Next() {
-		return rows.StructScan(dest)
-	} else {
-		if err := rows.Err(); err != nil {
-			return err
-		}
-		return sql.ErrNoRows
+	found := rows.Next()
+	if found {
+		err = rows.StructScan(dest)
+	}
+	// sqlite reports constraint violations of a RETURNING statement while reading the rows
+	if rowsErr := rows.Err(); err == nil {
+		err = rowsErr
+	}
+	// the rows hold on to the connection, so they are closed before the hooks run queries of their own, i.e. the EXPLAIN of a slow query
+	if closeErr := rows.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return c.finish(nil, -1, err)
+	}
+	if !found {
+		return c.finish(nil, 0, ErrNoRows)
 	}
+	return c.finish(dest, 1, nil)
 }
 
-func NamedExec(db *sqlx.DB, dest any, query string, args ...any) error {
-	rows, err := db.NamedExec(query, args)
-	log.Trace().Err(err).Any("result", rows).Str("_query", query).Any("args", args).Msg("NAMED_EXEC")
+// `dest` is unused, it is only kept so existing calls keep compiling. Use `NamedExecResult` for the `sql.Result`.
+func NamedExec(db Querier, dest any, query string, args ...any) error {
+	return NamedExecContext(context.Background(), db, dest, query, args...)
+}
+
+func NamedExecContext(ctx context.Context, db Querier, dest any, query string, args ...any) error {
+	_, err := NamedExecResultContext(ctx, db, query, args...)
 	return err
 }
+
+// Same as `ExecResult`, but with named args.
+func NamedExecResult(db Querier, query string, args ...any) (sql.Result, error) {
+	return NamedExecResultContext(context.Background(), db, query, args...)
+}
+
+func NamedExecResultContext(ctx context.Context, db Querier, query string, args ...any) (sql.Result, error) {
+	return execContext(ctx, db, "NAMED_EXEC", query, args, -1)
+}
+
+// Same as `ExecExpect`, but with named args.
+func NamedExecExpect(db Querier, n int64, query string, args ...any) (sql.Result, error) {
+	return NamedExecExpectContext(context.Background(), db, n, query, args...)
+}
+
+func NamedExecExpectContext(ctx context.Context, db Querier, n int64, query string, args ...any) (sql.Result, error) {
+	return execContext(ctx, db, "NAMED_EXEC", query, args, n)
+}
+
+// Runs EXEC or NAMED_EXEC, checking that exactly `expected` rows were affected unless it is negative.
+func execContext(ctx context.Context, db Querier, op string, query string, args []any, expected int64) (sql.Result, error) {
+	c := startQuery(ctx, db, op, query, args)
+	var r sql.Result
+	err := c.err
+	if err == nil && op == "NAMED_EXEC" {
+		r, err = namedExec(c.ctx, c.q, query, namedArg(args))
+	} else if err == nil {
+		r, err = c.q.ExecContext(c.ctx, c.query, c.args...)
+	}
+	n := rowsAffected(r)
+	if err == nil && expected >= 0 && n != expected {
+		err = &RowsAffectedError{Expected: expected, Actual: n}
+	}
+	return r, c.finish(r, n, err)
+}
+
+// Like `NamedExecReturning`, but scans every returned row instead of only the first, i.e. for `UPDATE ... RETURNING *` touching many rows.
+//
+// Passing a slice of structs or maps as the only arg runs `GeneratedQueries.Insert` and `GeneratedQueries.Upsert` as one batched insert, returning all the inserted rows.
+func NamedSelectReturning[T any](db Querier, query string, args ...any) (t []T, err error) {
+	return NamedSelectReturningContext[T](context.Background(), db, query, args...)
+}
+
+func NamedSelectReturningContext[T any](ctx context.Context, db Querier, query string, args ...any) (t []T, err error) {
+	c := startQuery(ctx, db, "NAMED_SELECT_RET", query, args)
+	boundQuery, boundArgs, err := bindNamed(c.q, query, namedArg(args))
+	if err == nil {
+		err = c.q.SelectContext(c.ctx, &t, boundQuery, boundArgs...)
+	}
+	err = c.finish(t, int64(len(t)), err)
+	return
+}
+
+// Same as `NamedSelectReturning`, but with positional args.
+func SelectReturning[T any](db Querier, query string, args ...any) (t []T, err error) {
+	return SelectReturningContext[T](context.Background(), db, query, args...)
+}
+
+func SelectReturningContext[T any](ctx context.Context, db Querier, query string, args ...any) (t []T, err error) {
+	c := startQuery(ctx, db, "SELECT_RET", query, args)
+	err = c.err
+	if err == nil {
+		err = c.q.SelectContext(c.ctx, &t, c.query, c.args...)
+	}
+	err = c.finish(t, int64(len(t)), err)
+	return
+}
+
+// Like `Select`, but returns an iterator that scans the rows one at a time instead of loading the whole result set into memory.
+//
+//	for row, err := range SelectIter[Table](db, queries.Select) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// The query runs when iteration starts, and the rows are closed once iteration is done, including when the loop is exited early. An error ends the iteration. Struct types are scanned with `StructScan`, any other type must be a single column.
+func SelectIter[T any](db Querier, query string, args ...any) iter.Seq2[T, error] {
+	return SelectIterContext[T](context.Background(), db, query, args...)
+}
+
+func SelectIterContext[T any](ctx context.Context, db Querier, query string, args ...any) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var t T
+		var count int64
+		c := startQuery(ctx, db, "SELECT_ITER", query, args)
+		// the time spent in the caller's loop body is not part of the query
+		next := yield
+		yield = func(t T, err error) bool {
+			start := time.Now()
+			defer func() { c.excluded += time.Since(start) }()
+			return next(t, err)
+		}
+		err := c.err
+		var rows *sqlx.Rows
+		if err == nil {
+			rows, err = c.q.QueryxContext(c.ctx, c.query, c.args...)
+		}
+		defer func() { _ = c.finish(nil, count, err) }()
+		if err != nil {
+			yield(t, classifyError(err))
+			return
+		}
+		defer func() { _ = rows.Close() }()
+
+		structScan := isStructScannable(reflect.TypeFor[T]())
+		for rows.Next() {
+			// database/sql closes the rows of a cancelled query asynchronously, so check here to stop right away
+			if err = c.ctx.Err(); err != nil {
+				yield(*new(T), err)
+				return
+			}
+			t = *new(T)
+			if structScan {
+				err = rows.StructScan(&t)
+			} else {
+				err = rows.Scan(&t)
+			}
+			if err != nil {
+				yield(t, err)
+				return
+			}
+			count++
+			if !yield(t, nil) {
+				return
+			}
+		}
+		if err = classifyError(rows.Err()); err != nil {
+			yield(*new(T), err)
+		}
+	}
+}
+
+// Returns 1 for a single row that was scanned successfully, otherwise 0.
+func rowCount(ok bool) int64 {
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+// Returns the rows affected of `r`, or -1 when unknown.
+func rowsAffected(r sql.Result) int64 {
+	if r == nil {
+		return -1
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// Structs are scanned by column name, unless they scan themselves like `sql.NullString` or have no exported fields like `time.Time`.
+func isStructScannable(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || reflect.PointerTo(t).Implements(reflect.TypeFor[sql.Scanner]()) {
+		return false
+	}
+	return slices.ContainsFunc(reflect.VisibleFields(t), func(f reflect.StructField) bool { return f.IsExported() })
+}
+
+// A single named arg is bound on its own, so a slice of structs is bound as a batch rather than as one element of `args`.
+func namedArg(args []any) any {
+	if len(args) == 1 {
+		return args[0]
+	}
+	return args
+}
+
+// Returns the index just past the string literal, quoted identifier or comment starting at `query[i]`, or `i` if none starts there. Placeholders inside these are left alone.
+func skipQuoted(query string, i int) int {
+	switch {
+	case query[i] == '\'' || query[i] == '"':
+		end := strings.IndexByte(query[i+1:], query[i])
+		if end < 0 {
+			return len(query)
+		}
+		return i + end + 2
+	case strings.HasPrefix(query[i:], "--"):
+		end := strings.IndexByte(query[i:], '\n')
+		if end < 0 {
+			return len(query)
+		}
+		return i + end
+	case strings.HasPrefix(query[i:], "/*"):
+		end := strings.Index(query[i+2:], "*/")
+		if end < 0 {
+			return len(query)
+		}
+		return i + end + 4
+	}
+	return i
+}
+
+// Reports whether `query` uses `$1` style placeholders outside of quotes and comments.
+func hasDollarPlaceholders(query string) bool {
+	for i := 0; i < len(query); i++ {
+		if j := skipQuoted(query, i); j > i {
+			i = j - 1
+			continue
+		}
+		if query[i] == '$' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9' {
+			return true
+		}
+	}
+	return false
+}
+
+// Rebinds the `?` placeholders of a positional `query` for the driver of `db`, i.e. to `$1` for postgres. `??` is left in the query as a literal `?`, as are question marks inside quotes and comments.
+//
+// Queries without args and queries already using `$1` style placeholders are run as is, so operators like the jsonb `?` keep working in them.
+//
+// The bindtype is taken from `db.Rebind`, as *sqlx.Conn does not expose its driver name.
+func rebind(db Querier, query string, args []any) string {
+	if len(args) == 0 || !strings.Contains(query, "?") || hasDollarPlaceholders(query) {
+		return query
+	}
+	placeholder := db.Rebind("?")
+
+	b := strings.Builder{}
+	n := 0
+	for i := 0; i < len(query); i++ {
+		if j := skipQuoted(query, i); j > i {
+			b.WriteString(query[i:j])
+			i = j - 1
+			continue
+		}
+		ch := query[i]
+		switch {
+		case ch == '?' && i+1 < len(query) && query[i+1] == '?':
+			i++
+		case ch == '?':
+			n++
+			// i.e. "$1", ":arg1" or "@p1"
+			b.WriteString(strings.TrimSuffix(placeholder, "1"))
+			if placeholder != "?" {
+				b.WriteString(strconv.Itoa(n))
+			}
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+// *sqlx.Conn cannot bind named queries itself, so named queries are bound here, slice args are expanded and the query is rebound for the driver.
+func bindNamed(db Querier, query string, arg any) (string, []any, error) {
+	query, args, err := sqlx.Named(query, arg)
+	if err != nil {
+		return "", nil, err
+	}
+	query, args, err = expandIn(query, args)
+	if err != nil {
+		return "", nil, err
+	}
+	return db.Rebind(query), args, nil
+}
+
+func namedQuery(ctx context.Context, db Querier, query string, arg any) (*sqlx.Rows, error) {
+	query, args, err := bindNamed(db, query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return db.QueryxContext(ctx, query, args...)
+}
+
+func namedExec(ctx context.Context, db Querier, query string, arg any) (sql.Result, error) {
+	query, args, err := bindNamed(db, query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return db.ExecContext(ctx, query, args...)
+}
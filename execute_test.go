@@ -0,0 +1,522 @@
+package boilerplate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Never terminates on its own, so it can only finish by being interrupted.
+const infiniteQuery = `WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT COUNT(*) FROM c`
+
+func TestContextCancel(t *testing.T) {
+	// use a separate db, as an interrupted query should not affect the shared one
+	db, err := Connect(DriverSqlite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	t.Run("GetContext", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err := GetContext[int64](ctx, db, infiniteQuery)
+		if err == nil {
+			t.Fatal("Expected cancelled query to return an error, but got nil.")
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Expected cancelled query to abort quickly, but it took %s", time.Since(start))
+		}
+	})
+
+	t.Run("SelectContext", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+		}()
+
+		start := time.Now()
+		_, err := SelectContext[[]int64](ctx, db, infiniteQuery)
+		if err == nil {
+			t.Fatal("Expected cancelled query to return an error, but got nil.")
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Expected cancelled query to abort quickly, but it took %s", time.Since(start))
+		}
+	})
+
+	t.Run("ExecContext", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := ExecContext(ctx, db, "CREATE TABLE cancelled (id INTEGER)")
+		if err == nil {
+			t.Fatal("Expected exec with a cancelled context to return an error, but got nil.")
+		}
+	})
+
+	t.Run("still usable", func(t *testing.T) {
+		n, err := GetContext[int64](context.Background(), db, "SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Fatalf("Expected 1, got %d", n)
+		}
+	})
+}
+
+func TestQuerier(t *testing.T) {
+	LoadDB(t)
+
+	type Table struct {
+		ID    int    `db:"id" dbtype:"BIGSERIAL NOT NULL PRIMARY KEY"`
+		Value string `db:"value" dbtype:"TEXT NOT NULL"`
+	}
+	queries := GenerateQueries(GenerateQueriesOptions{
+		TableName:          "table_querier",
+		Model:              Table{},
+		AutoGeneratingCols: []string{"id"},
+		PrimaryKeys:        []string{"id"},
+		Driver:             DriverSqlite,
+	})
+
+	t.Cleanup(func() {
+		_ = Exec(db, queries.DropTable)
+	})
+
+	err := Exec(db, queries.CreateTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("tx", func(t *testing.T) {
+		tx, err := db.Beginx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		row := Table{Value: "tx"}
+		err = NamedExecReturning(tx, &row, queries.Insert, &row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := Get[Table](tx, queries.Select+" WHERE id = $1", row.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertStructEqual(t, row, out, "Expected row selected inside the transaction to match inserted row")
+
+		err = tx.Rollback()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := Select[[]Table](db, queries.Select+" WHERE id = $1", row.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 0 {
+			t.Fatalf("Expected rolled back row to be gone, but got %d rows", len(rows))
+		}
+	})
+
+	t.Run("conn", func(t *testing.T) {
+		conn, err := db.Connx(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = conn.Close() }()
+
+		row := Table{Value: "conn"}
+		err = NamedExecReturning(conn, &row, queries.Insert, &row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = NamedExec(conn, nil, "UPDATE table_querier SET value = :value WHERE id = :id", Table{ID: row.ID, Value: "conn2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := Get[Table](conn, queries.Select+" WHERE id = $1", row.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.Value != "conn2" {
+			t.Fatalf("Expected updated value 'conn2', got '%s'", out.Value)
+		}
+	})
+}
+
+func TestRebind(t *testing.T) {
+	postgres := sqlx.NewDb(nil, DriverPostgres)
+	sqlite := sqlx.NewDb(nil, DriverSqlite)
+
+	tests := []struct {
+		db       Querier
+		query    string
+		args     []any
+		expected string
+	}{
+		{postgres, "SELECT * FROM t WHERE a = ? AND b = ?", []any{1, 2}, "SELECT * FROM t WHERE a = $1 AND b = $2"},
+		{postgres, "SELECT * FROM t WHERE a = $1 AND b = '?'", []any{1}, "SELECT * FROM t WHERE a = $1 AND b = '?'"},
+		{postgres, `SELECT * FROM t WHERE "a?" = ? AND b = 'it''s ?' AND c = ?`, []any{1, 2}, `SELECT * FROM t WHERE "a?" = $1 AND b = 'it''s ?' AND c = $2`},
+		{postgres, "SELECT * FROM t WHERE data ?? 'key' AND id = ?", []any{1}, "SELECT * FROM t WHERE data ? 'key' AND id = $1"},
+		{postgres, "SELECT * FROM t WHERE data ? 'key' AND data ?| array['a'] AND data ?& array['b']", nil, "SELECT * FROM t WHERE data ? 'key' AND data ?| array['a'] AND data ?& array['b']"},
+		{postgres, "SELECT * FROM t WHERE price = '$5' AND id = ?", []any{1}, "SELECT * FROM t WHERE price = '$5' AND id = $1"},
+		{postgres, "SELECT * FROM t -- was $1, now ?\nWHERE a = ? /* or ? */ AND b = ?", []any{1, 2}, "SELECT * FROM t -- was $1, now ?\nWHERE a = $1 /* or ? */ AND b = $2"},
+		{sqlite, "SELECT * FROM t WHERE a = ? AND b = ?", []any{1, 2}, "SELECT * FROM t WHERE a = ? AND b = ?"},
+		{sqlite, "SELECT * FROM t WHERE a = $1", []any{1}, "SELECT * FROM t WHERE a = $1"},
+		{sqlx.NewDb(nil, "sqlserver"), "SELECT * FROM t WHERE a = ?", []any{1}, "SELECT * FROM t WHERE a = @p1"},
+	}
+	for _, test := range tests {
+		if got := rebind(test.db, test.query, test.args); got != test.expected {
+			t.Fatalf("Expected %q to be rebound to %q, got %q", test.query, test.expected, got)
+		}
+	}
+
+	LoadDB(t)
+	if _, err := Get[int](db, "SELECT ? + ?", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := db.Connx(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	sum, err := GetContext[int](context.Background(), conn, "SELECT ? + ?", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != 3 {
+		t.Fatalf("Expected 3, got %d", sum)
+	}
+}
+
+func TestSelectReturning(t *testing.T) {
+	LoadDB(t)
+
+	type Table struct {
+		ID    int    `db:"id" dbtype:"BIGSERIAL NOT NULL PRIMARY KEY"`
+		Group string `db:"grp" dbtype:"TEXT NOT NULL"`
+		Value string `db:"value" dbtype:"TEXT NOT NULL"`
+	}
+	queries := GenerateQueries(GenerateQueriesOptions{
+		TableName:          "table_select_returning",
+		Model:              Table{},
+		AutoGeneratingCols: []string{"id"},
+		PrimaryKeys:        []string{"id"},
+		Driver:             DriverSqlite,
+	})
+
+	t.Cleanup(func() {
+		_ = Exec(db, queries.DropTable)
+	})
+
+	err := Exec(db, queries.CreateTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inserted, err := NamedSelectReturning[Table](db, queries.Insert, []Table{
+		{Group: "a", Value: "1"},
+		{Group: "a", Value: "2"},
+		{Group: "b", Value: "3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inserted) != 3 {
+		t.Fatalf("Expected 3 inserted rows to be returned, got %d", len(inserted))
+	}
+	for i, row := range inserted {
+		if row.ID == 0 {
+			t.Fatalf("Expected inserted row %d to have an id, but it's still 0.", i)
+		}
+	}
+	AssertStructEqual(t, []string{"1", "2", "3"}, []string{inserted[0].Value, inserted[1].Value, inserted[2].Value}, "Expected inserted rows to be returned in order")
+
+	updated, err := NamedSelectReturning[Table](db, "UPDATE table_select_returning SET value = value || :suffix WHERE grp = :grp RETURNING *", map[string]any{"suffix": "!", "grp": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertStructEqual(t, []Table{
+		{ID: inserted[0].ID, Group: "a", Value: "1!"},
+		{ID: inserted[1].ID, Group: "a", Value: "2!"},
+	}, updated, "Expected every updated row to be returned")
+
+	deleted, err := SelectReturning[int](db, "DELETE FROM table_select_returning WHERE grp = $1 RETURNING id", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertStructEqual(t, []int{inserted[0].ID, inserted[1].ID}, deleted, "Expected every deleted id to be returned")
+
+	none, err := SelectReturning[Table](db, "DELETE FROM table_select_returning WHERE grp = $1 RETURNING *", "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(none) != 0 {
+		t.Fatalf("Expected no rows to be returned, got %d", len(none))
+	}
+}
+
+func TestNamedGetSelect(t *testing.T) {
+	LoadDB(t)
+
+	type Table struct {
+		ID      int    `db:"id" dbtype:"INTEGER NOT NULL PRIMARY KEY"`
+		OwnerID int    `db:"owner_id" dbtype:"INTEGER NOT NULL"`
+		Value   string `db:"value" dbtype:"TEXT NOT NULL"`
+	}
+	queries := GenerateQueries(GenerateQueriesOptions{
+		TableName:          "table_named_get",
+		Model:              Table{},
+		AutoGeneratingCols: []string{"id"},
+		PrimaryKeys:        []string{"id"},
+		Driver:             DriverSqlite,
+	})
+
+	t.Cleanup(func() {
+		_ = Exec(db, queries.DropTable)
+	})
+
+	err := Exec(db, queries.CreateTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inserted, err := NamedSelectReturning[Table](db, queries.Insert, []Table{
+		{OwnerID: 1, Value: "a"},
+		{OwnerID: 1, Value: "b"},
+		{OwnerID: 2, Value: "c"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := NamedGet[Table](db, queries.Select+" WHERE id = :id", map[string]any{"id": inserted[1].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertStructEqual(t, inserted[1], got, "Expected the row to be found by a map arg")
+
+	value, err := NamedGet[string](db, "SELECT value FROM table_named_get WHERE owner_id = :owner_id AND value = :value", Table{OwnerID: 2, Value: "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "c" {
+		t.Fatalf("Expected a scalar to be scanned, got %q", value)
+	}
+
+	if _, err := NamedGet[Table](db, queries.Select+" WHERE id = :id", map[string]any{"id": 100}); !errors.Is(err, ErrNoRows) {
+		t.Fatalf("Expected ErrNoRows, got: %v", err)
+	}
+	if _, err := NamedGet[Table](db, queries.Select+" WHERE id = :id", map[string]any{}); err == nil {
+		t.Fatal("Expected an error for a missing named arg")
+	}
+
+	owned, err := NamedSelect[[]Table](db, queries.Select+" WHERE owner_id = :owner_id ORDER BY id", Table{OwnerID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertStructEqual(t, inserted[:2], owned, "Expected the rows of the owner to be selected by a struct arg")
+
+	none, err := NamedSelect[[]Table](db, queries.Select+" WHERE owner_id = :owner_id", map[string]any{"owner_id": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(none) != 0 {
+		t.Fatalf("Expected no rows, got %d", len(none))
+	}
+}
+
+func TestExecResult(t *testing.T) {
+	LoadDB(t)
+
+	type Table struct {
+		ID    int    `db:"id" dbtype:"INTEGER NOT NULL PRIMARY KEY"`
+		Value string `db:"value" dbtype:"TEXT NOT NULL"`
+	}
+	queries := GenerateQueries(GenerateQueriesOptions{
+		TableName:          "table_exec_result",
+		Model:              Table{},
+		AutoGeneratingCols: []string{"id"},
+		PrimaryKeys:        []string{"id"},
+		Driver:             DriverSqlite,
+	})
+
+	t.Cleanup(func() {
+		_ = Exec(db, queries.DropTable)
+	})
+
+	err := Exec(db, queries.CreateTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := ExecResult(db, "INSERT INTO table_exec_result (value) VALUES ($1), ($2)", "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.RowsAffected(); err != nil || n != 2 {
+		t.Fatalf("Expected 2 rows affected, got %d (%v)", n, err)
+	}
+	if id, err := r.LastInsertId(); err != nil || id != 2 {
+		t.Fatalf("Expected last insert id 2, got %d (%v)", id, err)
+	}
+
+	r, err = NamedExecResult(db, queries.Insert, Table{Value: "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := r.LastInsertId(); err != nil || id != 3 {
+		t.Fatalf("Expected last insert id 3, got %d (%v)", id, err)
+	}
+
+	t.Run("expect", func(t *testing.T) {
+		if _, err := ExecExpect(db, 1, "UPDATE table_exec_result SET value = $1 WHERE id = $2", "a!", 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NamedExecExpect(db, 1, queries.Update, Table{ID: 2, Value: "b!"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NamedExecExpect(db, 0, "DELETE FROM table_exec_result WHERE value = :value", map[string]any{"value": "missing"}); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := ExecExpect(db, 1, "DELETE FROM table_exec_result WHERE id = $1", 100)
+		var rowsErr *RowsAffectedError
+		if !errors.As(err, &rowsErr) || !errors.Is(err, ErrUnexpectedRowsAffected) || !errors.Is(err, ErrNoRows) {
+			t.Fatalf("Expected a RowsAffectedError matching ErrNoRows, got: %v", err)
+		}
+		AssertStructEqual(t, RowsAffectedError{Expected: 1, Actual: 0}, *rowsErr, "Expected the counts to be reported")
+
+		r, err := ExecExpect(db, 1, "UPDATE table_exec_result SET value = value || '?'")
+		if !errors.As(err, &rowsErr) || errors.Is(err, ErrNoRows) {
+			t.Fatalf("Expected a RowsAffectedError not matching ErrNoRows, got: %v", err)
+		}
+		AssertStructEqual(t, RowsAffectedError{Expected: 1, Actual: 3}, *rowsErr, "Expected the counts to be reported")
+		if n, _ := r.RowsAffected(); n != 3 {
+			t.Fatalf("Expected the result to still be returned, got %d rows affected", n)
+		}
+	})
+}
+
+func TestSelectIter(t *testing.T) {
+	// a single connection, so rows that are left open block every query after them
+	db, err := Connect(DriverSqlite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	type Table struct {
+		ID    int    `db:"id" dbtype:"BIGSERIAL NOT NULL PRIMARY KEY"`
+		Value string `db:"value" dbtype:"TEXT NOT NULL"`
+	}
+	queries := GenerateQueries(GenerateQueriesOptions{
+		TableName:          "table_select_iter",
+		Model:              Table{},
+		AutoGeneratingCols: []string{"id"},
+		PrimaryKeys:        []string{"id"},
+		Driver:             DriverSqlite,
+	})
+
+	err = Exec(db, queries.CreateTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Exec(db, `WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 1000) INSERT INTO table_select_iter (value) SELECT 'row ' || x FROM c`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("all rows", func(t *testing.T) {
+		count := 0
+		for row, err := range SelectIter[Table](db, queries.Select+" ORDER BY id") {
+			if err != nil {
+				t.Fatal(err)
+			}
+			count++
+			if row.ID != count || row.Value != fmt.Sprintf("row %d", count) {
+				t.Fatalf("Expected row %d, got %+v", count, row)
+			}
+		}
+		if count != 1000 {
+			t.Fatalf("Expected 1000 rows, got %d", count)
+		}
+	})
+
+	t.Run("scalar", func(t *testing.T) {
+		sum := 0
+		for id, err := range SelectIter[int](db, "SELECT id FROM table_select_iter") {
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum += id
+		}
+		if sum != 500500 {
+			t.Fatalf("Expected the ids to sum to 500500, got %d", sum)
+		}
+	})
+
+	t.Run("early exit closes the rows", func(t *testing.T) {
+		count := 0
+		for _, err := range SelectIter[Table](db, queries.Select) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			count++
+			if count == 10 {
+				break
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		n, err := GetContext[int](ctx, db, "SELECT COUNT(*) FROM table_select_iter")
+		if err != nil {
+			t.Fatalf("Expected the connection to be released after breaking out of the loop: %v", err)
+		}
+		if n != 1000 {
+			t.Fatalf("Expected 1000 rows, got %d", n)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		count := 0
+		for _, err := range SelectIter[Table](db, "SELECT * FROM does_not_exist") {
+			count++
+			if err == nil {
+				t.Fatal("Expected an error for a missing table, but got nil.")
+			}
+		}
+		if count != 1 {
+			t.Fatalf("Expected the error to be yielded once, got %d iterations", count)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		count := 0
+		var lastErr error
+		for _, err := range SelectIterContext[Table](ctx, db, queries.Select) {
+			if err != nil {
+				lastErr = err
+				break
+			}
+			count++
+			if count == 10 {
+				cancel()
+			}
+		}
+		if lastErr == nil {
+			t.Fatal("Expected cancelling the context to end the iteration with an error, but got nil.")
+		}
+	})
+}
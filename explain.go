@@ -0,0 +1,95 @@
+package boilerplate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// How long EXPLAIN may take, independent of the deadline of the query being explained.
+const explainTimeout = 5 * time.Second
+
+var explainableStatements = []string{"SELECT", "INSERT", "UPDATE", "DELETE", "WITH", "VALUES"}
+
+// Returns the query plan of the query in `event`, or nil if it cannot be explained.
+func explain(ctx context.Context, event *QueryEvent) (plan []string, err error) {
+	q := event.q
+	// EXPLAIN strings would only push the statements worth caching out of the statement cache
+	if w, ok := q.(*DB); ok {
+		q = w.DB
+	}
+	driverNamer, ok := q.(interface{ DriverName() string })
+	if !ok {
+		return nil, nil
+	}
+	statement := strings.ToUpper(strings.TrimSpace(event.Query))
+	if !hasAnyPrefix(statement, explainableStatements) {
+		return nil, nil
+	}
+
+	query, args := event.Query, event.Args
+	if strings.HasPrefix(event.Op, "NAMED_") {
+		query, args, err = bindNamed(q, query, namedArg(args))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	driver := Driver(driverNamer.DriverName())
+	switch driver {
+	case DriverPostgres:
+		query = "EXPLAIN " + query
+	case DriverSqlite:
+		query = "EXPLAIN QUERY PLAN " + query
+	default:
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), explainTimeout)
+	defer cancel()
+
+	// a failed statement aborts a postgres transaction, so EXPLAIN inside one runs in a savepoint
+	if tx, ok := q.(*sqlx.Tx); ok && driver == DriverPostgres {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT explain"); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				_, _ = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT explain")
+			}
+			_, _ = tx.ExecContext(ctx, "RELEASE SAVEPOINT explain")
+		}()
+	}
+
+	rows, err := q.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		cols, err := rows.SliceScan()
+		if err != nil {
+			return nil, err
+		}
+		// postgres returns one line of the plan per row, sqlite returns the line in the last column, after its id, parent id and an unused column
+		line := cols[len(cols)-1]
+		if b, ok := line.([]byte); ok {
+			line = string(b)
+		}
+		plan = append(plan, fmt.Sprint(line))
+	}
+	return plan, rows.Err()
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -38,54 +38,11 @@ type GeneratedQueries struct {
 //
 // `PrimaryKeys` is a list of col names that are used to control what is done on an UPDATE query.
 func GenerateQueries(opts GenerateQueriesOptions) (queries GeneratedQueries) {
-	type col struct {
-		name string
-		sql  string
-	}
-
 	if len(opts.PrimaryKeys) == 0 {
 		panic("No primary key specified for table: " + opts.TableName)
 	}
 
-	modelType := reflect.TypeOf(opts.Model)
-
-	cols := []col{}
-
-	for i := range modelType.NumField() {
-		field := modelType.Field(i)
-		name := field.Tag.Get("db")
-		dbtype := strings.ToUpper(field.Tag.Get("dbtype"))
-		switch opts.Driver {
-		case DriverSqlite:
-			// sqlite does not support SERIAL, so we switch it to AUTOINCREMENT
-			if strings.Contains(dbtype, "SERIAL") {
-				dbtype = strings.ReplaceAll(dbtype, "BIGSERIAL", "INTEGER")
-				dbtype = strings.ReplaceAll(dbtype, "SERIAL", "INTEGER")
-				dbtype = strings.ReplaceAll(dbtype, "NOT NULL", "")
-				dbtype = dbtype + " AUTOINCREMENT"
-				dbtype = strings.ReplaceAll(dbtype, "  ", " ")
-			}
-			// sqlite does not support UUID, so we switch it to TEXT
-			if strings.Contains(dbtype, "UUID") {
-				dbtype = strings.ReplaceAll(dbtype, "UUID", "TEXT")
-			}
-		case DriverPostgres:
-			// postgres does not support AUTOINCREMENT, so we switch it to SERIAL
-			if strings.Contains(dbtype, "AUTOINCREMENT") {
-				dbtype = strings.ReplaceAll(dbtype, "AUTOINCREMENT", "")
-				dbtype = strings.ReplaceAll(dbtype, "INT", "SERIAL")
-			}
-		}
-
-		if name == "" || dbtype == "" || name == "-" || dbtype == "-" {
-			continue
-		}
-
-		cols = append(cols, col{
-			name: name,
-			sql:  dbtype,
-		})
-	}
+	cols := modelColumns(opts)
 
 	// CREATE TABLE
 	colStrings := []string{}
@@ -143,3 +100,53 @@ func GenerateQueries(opts GenerateQueriesOptions) (queries GeneratedQueries) {
 
 	return
 }
+
+type column struct {
+	name string
+	sql  string
+}
+
+// Returns the columns of `opts.Model` that have both a 'db' and 'dbtype' tag, with the types adjusted for `opts.Driver`.
+func modelColumns(opts GenerateQueriesOptions) []column {
+	modelType := reflect.TypeOf(opts.Model)
+
+	cols := []column{}
+
+	for i := range modelType.NumField() {
+		field := modelType.Field(i)
+		name := field.Tag.Get("db")
+		dbtype := strings.ToUpper(field.Tag.Get("dbtype"))
+		switch opts.Driver {
+		case DriverSqlite:
+			// sqlite does not support SERIAL, so we switch it to AUTOINCREMENT
+			if strings.Contains(dbtype, "SERIAL") {
+				dbtype = strings.ReplaceAll(dbtype, "BIGSERIAL", "INTEGER")
+				dbtype = strings.ReplaceAll(dbtype, "SERIAL", "INTEGER")
+				dbtype = strings.ReplaceAll(dbtype, "NOT NULL", "")
+				dbtype = dbtype + " AUTOINCREMENT"
+				dbtype = strings.ReplaceAll(dbtype, "  ", " ")
+			}
+			// sqlite does not support UUID, so we switch it to TEXT
+			if strings.Contains(dbtype, "UUID") {
+				dbtype = strings.ReplaceAll(dbtype, "UUID", "TEXT")
+			}
+		case DriverPostgres:
+			// postgres does not support AUTOINCREMENT, so we switch it to SERIAL
+			if strings.Contains(dbtype, "AUTOINCREMENT") {
+				dbtype = strings.ReplaceAll(dbtype, "AUTOINCREMENT", "")
+				dbtype = strings.ReplaceAll(dbtype, "INT", "SERIAL")
+			}
+		}
+
+		if name == "" || dbtype == "" || name == "-" || dbtype == "-" {
+			continue
+		}
+
+		cols = append(cols, column{
+			name: name,
+			sql:  dbtype,
+		})
+	}
+
+	return cols
+}
@@ -0,0 +1,276 @@
+package boilerplate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Describes a single call of one of the execute helpers.
+type QueryEvent struct {
+	// The helper that ran the query: GET, SELECT, NAMED_GET, NAMED_SELECT, EXEC, NAMED_EXEC, NAMED_EXEC_RET, NAMED_SELECT_RET, SELECT_RET or SELECT_ITER.
+	Op string
+	// For positional args, the query and args as run, i.e. with slice args expanded and the query rebound for the driver, see `Get`.
+	Query string
+	Args  []any
+
+	// The fields below are only set once the query is done, i.e. in AfterQuery.
+
+	// The scanned result for reads, or the `sql.Result` for EXEC and NAMED_EXEC.
+	Result any
+	// Rows affected for EXEC and NAMED_EXEC, rows returned for everything else, or -1 when unknown.
+	RowsAffected int64
+	// Without the time spent in the loop body for SELECT_ITER.
+	Duration time.Duration
+	Err      error
+
+	// The querier the query ran on, used to EXPLAIN slow queries.
+	q Querier
+}
+
+// Hooks are run around every query of the execute helpers, see `DBOptions.Hooks`.
+type QueryHook interface {
+	// Called before the query runs. The returned context is used to run the query and is passed on to AfterQuery.
+	BeforeQuery(ctx context.Context, event *QueryEvent) context.Context
+	AfterQuery(ctx context.Context, event *QueryEvent)
+}
+
+// Describes a transaction or savepoint opened by `WithTx`.
+type TxEvent struct {
+	// BEGIN, COMMIT or ROLLBACK for transactions, and SAVEPOINT, RELEASE SAVEPOINT or ROLLBACK TO SAVEPOINT for nested ones. In AfterTx this is the statement that ended it, or still BEGIN or SAVEPOINT if it could not be opened.
+	Op string
+	// The name of the savepoint, empty for transactions.
+	Savepoint string
+	Opts      *sql.TxOptions
+
+	// The fields below are only set in AfterTx.
+
+	// The error returned by, or the panic raised in, the transaction's fn when it was rolled back.
+	Cause    any
+	Duration time.Duration
+	Err      error
+}
+
+// Optionally implemented by a `QueryHook` to also be run around transactions.
+type TxHook interface {
+	// Called before the transaction or savepoint is opened. The returned context is passed on to the transaction's fn and to AfterTx.
+	BeforeTx(ctx context.Context, event *TxEvent) context.Context
+	// Called once the transaction or savepoint has been committed or rolled back, or failed to open.
+	AfterTx(ctx context.Context, event *TxEvent)
+}
+
+// Logs every query and transaction at trace level. This is the default hook when no others are set.
+type LogHook struct {
+	// Defaults to the global zerolog logger.
+	Logger *zerolog.Logger
+	// Queries taking longer than this are also logged at warn level as SLOW_QUERY. 0 disables it.
+	//
+	// For SELECT_ITER this is the time spent running the query and scanning the rows, the time spent in the loop body in between is left out, so a slow consumer of a fast query is not reported.
+	SlowQueryThreshold time.Duration
+	// Adds the query plan to the SLOW_QUERY log, from `EXPLAIN` on postgres and `EXPLAIN QUERY PLAN` on sqlite. This runs the EXPLAIN as an extra query, and is skipped for failed queries and statements that cannot be explained.
+	ExplainSlowQueries bool
+	// Positional args compared with or inserted into these columns are logged as `Redacted`, as are the matching fields and map keys of named args and results. Fields tagged `log:"redact"` are always redacted.
+	//
+	// Positional args are matched to columns by looking for the `col = $1` and `INSERT INTO t (col) VALUES ($1)` forms in the query, pass sensitive values as named args to be sure they are redacted.
+	RedactColumns []string
+	// Results longer than this many bytes of JSON are cut off and logged as a string, with "result_truncated" set. 0 logs the whole result.
+	MaxResultSize int
+}
+
+func (h LogHook) logger() *zerolog.Logger {
+	if h.Logger != nil {
+		return h.Logger
+	}
+	return &log.Logger
+}
+
+func (h LogHook) BeforeQuery(ctx context.Context, event *QueryEvent) context.Context {
+	return ctx
+}
+
+func (h LogHook) AfterQuery(ctx context.Context, event *QueryEvent) {
+	if e := h.logger().Trace(); e.Enabled() {
+		h.result(e.Err(event.Err), event).Str("_query", event.Query).Any("args", h.args(event)).Int64("rows", event.RowsAffected).Dur("duration", event.Duration).Msg(event.Op)
+	}
+
+	if h.SlowQueryThreshold <= 0 || event.Duration < h.SlowQueryThreshold {
+		return
+	}
+	e := h.logger().Warn()
+	if !e.Enabled() {
+		return
+	}
+	e = e.Err(event.Err).Str("op", event.Op).Str("_query", event.Query).Any("args", h.args(event)).Dur("duration", event.Duration).Dur("threshold", h.SlowQueryThreshold)
+	if h.ExplainSlowQueries && event.Err == nil {
+		plan, err := explain(ctx, event)
+		if err != nil {
+			e = e.AnErr("explain_error", err)
+		} else if plan != nil {
+			e = e.Strs("plan", plan)
+		}
+	}
+	e.Msg("SLOW_QUERY")
+}
+
+// Returns the args of `event` with the sensitive values redacted.
+func (h LogHook) args(event *QueryEvent) any {
+	r := redactor{columns: h.RedactColumns}
+	if strings.HasPrefix(event.Op, "NAMED_") {
+		return r.value(event.Args)
+	}
+	return r.positionalArgs(event.Query, event.Args)
+}
+
+// Adds the result of `event` to `e`, with the sensitive values redacted and truncated to `MaxResultSize`.
+func (h LogHook) result(e *zerolog.Event, event *QueryEvent) *zerolog.Event {
+	result := redactor{columns: h.RedactColumns}.value(event.Result)
+	if h.MaxResultSize <= 0 {
+		return e.Any("result", result)
+	}
+	s, truncated, err := truncatedJSON(result, h.MaxResultSize)
+	if err != nil {
+		return e.Any("result", result)
+	}
+	if truncated {
+		return e.Str("result", s).Bool("result_truncated", true)
+	}
+	return e.RawJSON("result", []byte(s))
+}
+
+func (h LogHook) BeforeTx(ctx context.Context, event *TxEvent) context.Context {
+	h.logger().Trace().Str("savepoint", event.Savepoint).Any("opts", event.Opts).Msg(event.Op)
+	return ctx
+}
+
+func (h LogHook) AfterTx(ctx context.Context, event *TxEvent) {
+	e := h.logger().Trace().Err(event.Err).Str("savepoint", event.Savepoint).Dur("duration", event.Duration)
+	if event.Cause != nil {
+		e = e.Str("cause", fmt.Sprint(event.Cause))
+	}
+	e.Msg(event.Op)
+}
+
+var defaultHooks = []QueryHook{LogHook{}}
+
+// Returns the hooks to run for `db`. A transaction opened by `WithTx` on a `*DB` uses the hooks of that `*DB`, whether it is found through `ctx` or passed in directly, and a `Cluster` uses the hooks of its primary.
+func hooksFor(ctx context.Context, db Querier) []QueryHook {
+	if state, ok := ctx.Value(txKey{}).(*txState); ok && (state.db == db || Querier(state.tx) == db) {
+		db = state.db
+	}
+	if tx, ok := db.(*sqlx.Tx); ok {
+		if state, ok := openTxs.Load(tx); ok {
+			db = state.(*txState).db
+		}
+	}
+	if r, ok := db.(router); ok {
+		db = r.route(ctx, true)
+	}
+	if w, ok := db.(*DB); ok {
+		return w.hooks
+	}
+	return defaultHooks
+}
+
+// A single call of an execute helper, created by `startQuery` and ended by `finish`.
+type queryCall struct {
+	ctx   context.Context
+	q     Querier
+	query string
+	args  []any
+	// Set when the positional args could not be expanded, returned by the helper instead of running the query.
+	err   error
+	hooks []QueryHook
+	event *QueryEvent
+	start time.Time
+	// Time spent outside the query since `start`, left out of the duration, i.e. in the loop body of SELECT_ITER.
+	excluded time.Duration
+}
+
+// Resolves the querier to run on for `db`, routing reads to a replica for a `Cluster`, expands and rebinds positional queries and runs the BeforeQuery hooks.
+func startQuery(ctx context.Context, db Querier, op string, query string, args []any) *queryCall {
+	q := QuerierFromContext(ctx, db)
+	if r, ok := q.(router); ok {
+		q = r.route(ctx, !readOps[op])
+	}
+	c := &queryCall{
+		q:     q,
+		hooks: hooksFor(ctx, q),
+	}
+	c.query, c.args = query, args
+	if !strings.HasPrefix(op, "NAMED_") {
+		c.query, c.args, c.err = expandIn(query, args)
+		if c.err != nil {
+			c.query, c.args = query, args
+		}
+		c.query = rebind(c.q, c.query, c.args)
+	}
+	c.event = &QueryEvent{Op: op, Query: c.query, Args: c.args, RowsAffected: -1, q: c.q}
+	for _, h := range c.hooks {
+		ctx = h.BeforeQuery(ctx, c.event)
+	}
+	c.ctx = ctx
+	c.start = time.Now()
+	return c
+}
+
+// Classifies `err`, runs the AfterQuery hooks in reverse order and returns the classified error.
+func (c *queryCall) finish(result any, rowsAffected int64, err error) error {
+	c.event.Duration = time.Since(c.start) - c.excluded
+	c.event.Result = result
+	c.event.RowsAffected = rowsAffected
+	c.event.Err = classifyError(err)
+	for i := len(c.hooks) - 1; i >= 0; i-- {
+		c.hooks[i].AfterQuery(c.ctx, c.event)
+	}
+	return c.event.Err
+}
+
+// Returns the number of rows in a result scanned by Select.
+func resultLen(result any) int64 {
+	v := reflect.ValueOf(result)
+	if v.Kind() != reflect.Slice {
+		return -1
+	}
+	return int64(v.Len())
+}
+
+// A transaction or savepoint opened by `WithTx`, created by `startTx` and ended by `finish`.
+type txCall struct {
+	ctx   context.Context
+	hooks []TxHook
+	event *TxEvent
+	start time.Time
+}
+
+// Runs the BeforeTx hooks of `db`.
+func startTx(ctx context.Context, db Querier, event *TxEvent) *txCall {
+	c := &txCall{event: event}
+	for _, h := range hooksFor(ctx, db) {
+		if h, ok := h.(TxHook); ok {
+			c.hooks = append(c.hooks, h)
+			ctx = h.BeforeTx(ctx, event)
+		}
+	}
+	c.ctx = ctx
+	c.start = time.Now()
+	return c
+}
+
+// Runs the AfterTx hooks in reverse order and returns `err`.
+func (c *txCall) finish(op string, cause any, err error) error {
+	c.event.Op = op
+	c.event.Cause = cause
+	c.event.Duration = time.Since(c.start)
+	c.event.Err = err
+	for i := len(c.hooks) - 1; i >= 0; i-- {
+		c.hooks[i].AfterTx(c.ctx, c.event)
+	}
+	return err
+}
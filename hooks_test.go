@@ -0,0 +1,322 @@
+package boilerplate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog"
+)
+
+type recordingHook struct {
+	name   string
+	calls  *[]string
+	events []QueryEvent
+	txs    []TxEvent
+}
+
+type recordingKey struct{}
+
+func (h *recordingHook) BeforeQuery(ctx context.Context, event *QueryEvent) context.Context {
+	*h.calls = append(*h.calls, h.name+" before "+event.Op)
+	return context.WithValue(ctx, recordingKey{}, h.name)
+}
+
+func (h *recordingHook) AfterQuery(ctx context.Context, event *QueryEvent) {
+	*h.calls = append(*h.calls, h.name+" after "+event.Op)
+	if ctx.Value(recordingKey{}) == nil {
+		panic("context returned by BeforeQuery was not passed to AfterQuery")
+	}
+	h.events = append(h.events, *event)
+}
+
+func (h *recordingHook) BeforeTx(ctx context.Context, event *TxEvent) context.Context {
+	*h.calls = append(*h.calls, h.name+" before "+event.Op)
+	return ctx
+}
+
+func (h *recordingHook) AfterTx(ctx context.Context, event *TxEvent) {
+	*h.calls = append(*h.calls, h.name+" after "+event.Op)
+	h.txs = append(h.txs, *event)
+}
+
+func TestHooks(t *testing.T) {
+	LoadDB(t)
+
+	type Table struct {
+		ID    int    `db:"id" dbtype:"BIGSERIAL NOT NULL PRIMARY KEY"`
+		Value string `db:"value" dbtype:"TEXT NOT NULL UNIQUE"`
+	}
+	queries := GenerateQueries(GenerateQueriesOptions{
+		TableName:          "table_hooks",
+		Model:              Table{},
+		AutoGeneratingCols: []string{"id"},
+		PrimaryKeys:        []string{"id"},
+		Driver:             DriverSqlite,
+	})
+
+	t.Cleanup(func() {
+		_ = Exec(db, queries.DropTable)
+	})
+
+	err := Exec(db, queries.CreateTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("queries", func(t *testing.T) {
+		calls := []string{}
+		first := &recordingHook{name: "first", calls: &calls}
+		second := &recordingHook{name: "second", calls: &calls}
+		hooked := NewDB(db, DBOptions{Hooks: []QueryHook{first, second}})
+
+		row := Table{Value: "a"}
+		if err := NamedExecReturning(hooked, &row, queries.Insert, &row); err != nil {
+			t.Fatal(err)
+		}
+		if err := Exec(hooked, "UPDATE table_hooks SET value = value || $1", "!"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Select[[]Table](hooked, queries.Select); err != nil {
+			t.Fatal(err)
+		}
+		dup := Table{Value: "a!"}
+		if err := NamedExecReturning(hooked, &dup, queries.Insert, &dup); !errors.Is(err, ErrUniqueViolation) {
+			t.Fatalf("Expected a unique violation, got: %v", err)
+		}
+
+		AssertStructEqual(t, []string{
+			"first before NAMED_EXEC_RET", "second before NAMED_EXEC_RET", "second after NAMED_EXEC_RET", "first after NAMED_EXEC_RET",
+			"first before EXEC", "second before EXEC", "second after EXEC", "first after EXEC",
+			"first before SELECT", "second before SELECT", "second after SELECT", "first after SELECT",
+			"first before NAMED_EXEC_RET", "second before NAMED_EXEC_RET", "second after NAMED_EXEC_RET", "first after NAMED_EXEC_RET",
+		}, calls, "Expected BeforeQuery to run in order and AfterQuery in reverse order")
+
+		events := first.events
+		if len(events) != 4 {
+			t.Fatalf("Expected 4 events, got %d", len(events))
+		}
+		if events[1].Query != "UPDATE table_hooks SET value = value || $1" || len(events[1].Args) != 1 || events[1].Args[0] != "!" {
+			t.Fatalf("Expected the query and args to be passed on, got %q %v", events[1].Query, events[1].Args)
+		}
+		if events[1].RowsAffected != 1 {
+			t.Fatalf("Expected 1 row affected by EXEC, got %d", events[1].RowsAffected)
+		}
+		if events[2].RowsAffected != 1 {
+			t.Fatalf("Expected 1 row returned by SELECT, got %d", events[2].RowsAffected)
+		}
+		for _, e := range events {
+			if e.Duration <= 0 {
+				t.Fatalf("Expected a duration for %s, got %s", e.Op, e.Duration)
+			}
+		}
+		if !errors.Is(events[3].Err, ErrUniqueViolation) {
+			t.Fatalf("Expected the classified error to be passed on, got: %v", events[3].Err)
+		}
+	})
+
+	t.Run("transactions", func(t *testing.T) {
+		calls := []string{}
+		hook := &recordingHook{name: "hook", calls: &calls}
+		hooked := NewDB(db, DBOptions{Hooks: []QueryHook{hook}})
+
+		errExpected := errors.New("expected")
+		err := WithTx(context.Background(), hooked, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+			if _, err := GetContext[int](ctx, hooked, "SELECT COUNT(*) FROM table_hooks"); err != nil {
+				return err
+			}
+			// passing the transaction itself still uses the hooks of the db it was opened on
+			if _, err := GetContext[int](ctx, tx, "SELECT COUNT(*) FROM table_hooks"); err != nil {
+				return err
+			}
+			_ = WithTx(ctx, hooked, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+				return errExpected
+			})
+			// and so does passing it without the context, to the helpers and to a nested WithTx
+			if _, err := Get[int](tx, "SELECT COUNT(*) FROM table_hooks"); err != nil {
+				return err
+			}
+			return WithTx(context.Background(), tx, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+				return Exec(tx, "UPDATE table_hooks SET value = value")
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		AssertStructEqual(t, []string{
+			"hook before BEGIN",
+			"hook before GET", "hook after GET",
+			"hook before GET", "hook after GET",
+			"hook before SAVEPOINT", "hook after ROLLBACK TO SAVEPOINT",
+			"hook before GET", "hook after GET",
+			"hook before SAVEPOINT", "hook before EXEC", "hook after EXEC", "hook after RELEASE SAVEPOINT",
+			"hook after COMMIT",
+		}, calls, "Expected the transaction to run through the hooks")
+
+		if hook.txs[0].Savepoint == "" || !errors.Is(hook.txs[0].Cause.(error), errExpected) {
+			t.Fatalf("Expected the savepoint rollback to carry its name and cause, got %+v", hook.txs[0])
+		}
+	})
+
+	t.Run("log hook", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		logger := zerolog.New(&buf).Level(zerolog.TraceLevel)
+		hooked := NewDB(db, DBOptions{Logger: &logger})
+
+		if _, err := Get[int](hooked, "SELECT COUNT(*) FROM table_hooks WHERE value = $1", "a!"); err != nil {
+			t.Fatal(err)
+		}
+		err := WithTx(context.Background(), hooked, nil, func(ctx context.Context, tx *sqlx.Tx) error { return nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("Expected 3 log lines, got %d:\n%s", len(lines), buf.String())
+		}
+		entry := map[string]any{}
+		if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry["message"] != "GET" || entry["level"] != "trace" || entry["_query"] != "SELECT COUNT(*) FROM table_hooks WHERE value = $1" || entry["result"] != float64(1) {
+			t.Fatalf("Expected the query to be logged to the injected logger, got: %s", lines[0])
+		}
+		if !strings.Contains(lines[1], `"message":"BEGIN"`) || !strings.Contains(lines[2], `"message":"COMMIT"`) {
+			t.Fatalf("Expected the transaction to be logged, got:\n%s", buf.String())
+		}
+	})
+
+	t.Run("slow queries", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		logger := zerolog.New(&buf).Level(zerolog.WarnLevel)
+		hooked := NewDB(db, DBOptions{Logger: &logger, SlowQueryThreshold: time.Nanosecond, ExplainSlowQueries: true})
+
+		if _, err := Select[[]Table](hooked, "SELECT * FROM table_hooks WHERE id > $1", 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := NamedExec(hooked, nil, "UPDATE table_hooks SET value = :value WHERE value = :value", map[string]any{"value": "a!"}); err != nil {
+			t.Fatal(err)
+		}
+		err := WithTx(context.Background(), hooked, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+			_, err := GetContext[int](ctx, hooked, "SELECT COUNT(*) FROM table_hooks")
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Exec(hooked, "PRAGMA optimize"); err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 4 {
+			t.Fatalf("Expected 4 log lines, got %d:\n%s", len(lines), buf.String())
+		}
+		for i, line := range lines {
+			entry := map[string]any{}
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatal(err)
+			}
+			if entry["message"] != "SLOW_QUERY" || entry["level"] != "warn" || entry["explain_error"] != nil {
+				t.Fatalf("Expected a SLOW_QUERY warning, got: %s", line)
+			}
+			plan, _ := entry["plan"].([]any)
+			if i < 3 && (len(plan) == 0 || !strings.Contains(fmt.Sprint(plan...), "table_hooks")) {
+				t.Fatalf("Expected the query plan to be logged, got: %s", line)
+			}
+			if i == 3 && plan != nil {
+				t.Fatalf("Expected no plan for a statement that cannot be explained, got: %s", line)
+			}
+		}
+
+		buf.Reset()
+		hooked = NewDB(db, DBOptions{Logger: &logger})
+		if _, err := Select[[]Table](hooked, queries.Select); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("Expected nothing to be logged without a threshold, got: %s", buf.String())
+		}
+	})
+
+	t.Run("slow queries on a single connection", func(t *testing.T) {
+		single, err := Connect(DriverSqlite, ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = single.Close() })
+		single.SetMaxOpenConns(1)
+		for _, query := range []string{"CREATE TABLE single (id INTEGER PRIMARY KEY, value TEXT NOT NULL)", "INSERT INTO single (value) VALUES ('a')"} {
+			if err := Exec(single, query); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		buf := bytes.Buffer{}
+		logger := zerolog.New(&buf).Level(zerolog.WarnLevel)
+		hooked := NewDB(single, DBOptions{Logger: &logger, SlowQueryThreshold: time.Nanosecond, ExplainSlowQueries: true})
+
+		// the EXPLAIN needs the only connection, which the RETURNING rows must have released by then
+		start := time.Now()
+		row := struct {
+			ID    int    `db:"id"`
+			Value string `db:"value"`
+		}{Value: "a"}
+		if err := NamedExecReturning(hooked, &row, "UPDATE single SET value = value || '!' WHERE value = :value RETURNING *", &row); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("Expected the query to finish right away, took %s", elapsed)
+		}
+
+		entry := map[string]any{}
+		if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry["explain_error"] != nil || entry["plan"] == nil {
+			t.Fatalf("Expected the query plan to be logged, got: %s", buf.String())
+		}
+	})
+
+	t.Run("slow consumers of SELECT_ITER", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		logger := zerolog.New(&buf).Level(zerolog.WarnLevel)
+		calls := []string{}
+		hook := &recordingHook{name: "hook", calls: &calls}
+		hooked := NewDB(db, DBOptions{Hooks: []QueryHook{hook, LogHook{Logger: &logger, SlowQueryThreshold: 50 * time.Millisecond}}})
+
+		for _, err := range SelectIter[Table](hooked, queries.Select) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("Expected the loop body not to make the query slow, got: %s", buf.String())
+		}
+		if len(hook.events) != 1 || hook.events[0].Duration >= 50*time.Millisecond {
+			t.Fatalf("Expected the duration to leave out the loop body, got %+v", hook.events)
+		}
+	})
+
+	t.Run("no hooks", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		logger := zerolog.New(&buf).Level(zerolog.TraceLevel)
+		hooked := NewDB(db, DBOptions{Hooks: []QueryHook{}, Logger: &logger})
+
+		if _, err := Get[int](hooked, "SELECT COUNT(*) FROM table_hooks"); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("Expected nothing to be logged, got: %s", buf.String())
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package boilerplate
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Returned when an empty slice is passed to `NOT IN`, which cannot be expanded into a predicate that is always true the way `IN` is expanded into one that is always false.
+var ErrEmptyNotIn = errors.New("empty slice passed to NOT IN")
+
+var notInSuffix = regexp.MustCompile(`(?i)\bNOT\s+IN\s*\(\s*$`)
+
+// Expands the slice args of `query` into one placeholder per element, so `WHERE id IN (?)` with `[]int64{1, 2}` runs as `WHERE id IN (?, ?)`. Works with both `?` and `$1` placeholders, `$1` placeholders are renumbered.
+//
+// An empty slice is replaced with NULL, as `IN ()` is a syntax error while `IN (NULL)` matches nothing.
+//
+// Slices that implement `driver.Valuer` like `StringSlice` and `IntSlice` are passed on as a single value, as is `[]byte`.
+func expandIn(query string, args []any) (string, []any, error) {
+	slices := make([]reflect.Value, len(args))
+	found := false
+	for i, arg := range args {
+		if v, ok := inSlice(arg); ok {
+			slices[i] = v
+			found = true
+		}
+	}
+	if !found {
+		return query, args, nil
+	}
+
+	dollar := hasDollarPlaceholders(query)
+
+	// the new position, starting at 1, of every old arg, used to renumber "$1" placeholders
+	positions := make([]int, len(args))
+	expandedArgs := []any{}
+	for i, arg := range args {
+		positions[i] = len(expandedArgs) + 1
+		if !slices[i].IsValid() {
+			expandedArgs = append(expandedArgs, arg)
+			continue
+		}
+		for j := range slices[i].Len() {
+			expandedArgs = append(expandedArgs, slices[i].Index(j).Interface())
+		}
+	}
+
+	// writes the placeholders of the old arg `i` to `b`
+	b := strings.Builder{}
+	writePlaceholders := func(i int) error {
+		if i < 0 || i >= len(args) {
+			// left for the driver to report
+			if dollar {
+				b.WriteString("$" + strconv.Itoa(i+1))
+			} else {
+				b.WriteString("?")
+			}
+			return nil
+		}
+		n := 1
+		if slices[i].IsValid() {
+			n = slices[i].Len()
+		}
+		if n == 0 {
+			if notInSuffix.MatchString(b.String()) {
+				return ErrEmptyNotIn
+			}
+			b.WriteString("NULL")
+			return nil
+		}
+		for j := range n {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			if dollar {
+				b.WriteString("$" + strconv.Itoa(positions[i]+j))
+			} else {
+				b.WriteString("?")
+			}
+		}
+		return nil
+	}
+
+	next := 0
+	for i := 0; i < len(query); i++ {
+		if j := skipQuoted(query, i); j > i {
+			b.WriteString(query[i:j])
+			i = j - 1
+			continue
+		}
+		ch := query[i]
+		switch {
+		case !dollar && ch == '?' && i+1 < len(query) && query[i+1] == '?':
+			b.WriteString("??")
+			i++
+			continue
+		case !dollar && ch == '?':
+			if err := writePlaceholders(next); err != nil {
+				return "", nil, err
+			}
+			next++
+			continue
+		case dollar && ch == '$' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9':
+			end := i + 1
+			for end < len(query) && query[end] >= '0' && query[end] <= '9' {
+				end++
+			}
+			n, _ := strconv.Atoi(query[i+1 : end])
+			if err := writePlaceholders(n - 1); err != nil {
+				return "", nil, err
+			}
+			i = end - 1
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return b.String(), expandedArgs, nil
+}
+
+// Returns the slice to expand for `arg`, or false if it is passed on as a single value.
+func inSlice(arg any) (reflect.Value, bool) {
+	if arg == nil {
+		return reflect.Value{}, false
+	}
+	if _, ok := arg.(driver.Valuer); ok {
+		return reflect.Value{}, false
+	}
+	v := reflect.ValueOf(arg)
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() || v.Type().Elem().Implements(reflect.TypeFor[driver.Valuer]()) {
+			return reflect.Value{}, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() == reflect.Uint8 {
+		return reflect.Value{}, false
+	}
+	return v, true
+}
@@ -0,0 +1,101 @@
+package boilerplate
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExpandIn(t *testing.T) {
+	tests := []struct {
+		query        string
+		args         []any
+		expected     string
+		expectedArgs []any
+	}{
+		{"SELECT * FROM t WHERE id IN (?)", []any{[]int64{1, 2, 3}}, "SELECT * FROM t WHERE id IN (?, ?, ?)", []any{int64(1), int64(2), int64(3)}},
+		{"SELECT * FROM t WHERE a = ? AND id IN (?) AND b = ?", []any{"a", []string{"x", "y"}, "b"}, "SELECT * FROM t WHERE a = ? AND id IN (?, ?) AND b = ?", []any{"a", "x", "y", "b"}},
+		{"SELECT * FROM t WHERE id IN ($2) AND a = $1 AND b = $3 OR a = $1", []any{"a", []int{1, 2}, "b"}, "SELECT * FROM t WHERE id IN ($2, $3) AND a = $1 AND b = $4 OR a = $1", []any{"a", 1, 2, "b"}},
+		{"SELECT * FROM t WHERE id IN (?) AND a = '?' AND b ?? 'k' AND c = ?", []any{[]int{1, 2}, "c"}, "SELECT * FROM t WHERE id IN (?, ?) AND a = '?' AND b ?? 'k' AND c = ?", []any{1, 2, "c"}},
+		{"SELECT * FROM t WHERE id IN (?) AND a = ?", []any{[]int{}, "a"}, "SELECT * FROM t WHERE id IN (NULL) AND a = ?", []any{"a"}},
+		{"SELECT * FROM t WHERE id IN ($1) AND a = $2", []any{[]int{}, "a"}, "SELECT * FROM t WHERE id IN (NULL) AND a = $1", []any{"a"}},
+		{"SELECT * FROM t WHERE tags = ? AND data = ?", []any{StringSlice{"a", "b"}, []byte("x")}, "SELECT * FROM t WHERE tags = ? AND data = ?", []any{StringSlice{"a", "b"}, []byte("x")}},
+		{"SELECT * FROM t WHERE price = '$5' AND id IN (?) -- or $1", []any{[]int{1, 2}}, "SELECT * FROM t WHERE price = '$5' AND id IN (?, ?) -- or $1", []any{1, 2}},
+		{"SELECT * FROM t WHERE ids = ? AND id IN (?)", []any{&IntSlice{1}, &[]int{1}}, "SELECT * FROM t WHERE ids = ? AND id IN (?)", []any{&IntSlice{1}, 1}},
+	}
+	for _, test := range tests {
+		query, args, err := expandIn(test.query, test.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query != test.expected {
+			t.Fatalf("Expected %q to be expanded to %q, got %q", test.query, test.expected, query)
+		}
+		AssertStructEqual(t, test.expectedArgs, args, test.query)
+	}
+
+	if _, _, err := expandIn("SELECT * FROM t WHERE id NOT IN (?)", []any{[]int{}}); !errors.Is(err, ErrEmptyNotIn) {
+		t.Fatalf("Expected ErrEmptyNotIn, got: %v", err)
+	}
+}
+
+func TestInHelpers(t *testing.T) {
+	LoadDB(t)
+
+	type Table struct {
+		ID   int         `db:"id" dbtype:"INTEGER NOT NULL PRIMARY KEY"`
+		Tags StringSlice `db:"tags" dbtype:"TEXT[] NOT NULL"`
+	}
+	queries := GenerateQueries(GenerateQueriesOptions{
+		TableName:          "table_in",
+		Model:              Table{},
+		AutoGeneratingCols: []string{"id"},
+		PrimaryKeys:        []string{"id"},
+		Driver:             DriverSqlite,
+	})
+
+	t.Cleanup(func() {
+		_ = Exec(db, queries.DropTable)
+	})
+
+	err := Exec(db, queries.CreateTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tags := range []StringSlice{{"a"}, {"b"}, {"a", "b"}} {
+		if err := Exec(db, "INSERT INTO table_in (tags) VALUES (?)", tags); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids, err := Select[[]int](db, "SELECT id FROM table_in WHERE id IN (?) ORDER BY id", []int64{1, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertStructEqual(t, []int{1, 3}, ids, "Expected the slice to be expanded")
+
+	ids, err = Select[[]int](db, "SELECT id FROM table_in WHERE id IN ($1) OR tags = $2 ORDER BY id", []int64{1}, StringSlice{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertStructEqual(t, []int{1, 3}, ids, "Expected the StringSlice to be passed as a single value")
+
+	ids, err = Select[[]int](db, "SELECT id FROM table_in WHERE id IN (?)", []int64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Fatalf("Expected an empty slice to match nothing, got %v", ids)
+	}
+
+	deleted, err := NamedExecResult(db, "DELETE FROM table_in WHERE id IN (:ids)", map[string]any{"ids": []int{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := deleted.RowsAffected(); n != 2 {
+		t.Fatalf("Expected the named slice to be expanded, got %d rows affected", n)
+	}
+
+	if _, err := Get[int](db, "SELECT COUNT(*) FROM table_in WHERE id NOT IN (?)", []int{}); !errors.Is(err, ErrEmptyNotIn) {
+		t.Fatalf("Expected ErrEmptyNotIn, got: %v", err)
+	}
+}
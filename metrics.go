@@ -0,0 +1,117 @@
+package boilerplate
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Receives the metrics of `MetricsHook` and `WatchDBStats`. `PrometheusMetrics` serves them in the Prometheus text format.
+type MetricsCollector interface {
+	// Called once for every query of the execute helpers. `op` is the helper that ran it, see `QueryEvent.Op`, and `fingerprint` the normalized query, see `Fingerprint`.
+	ObserveQuery(op string, fingerprint string, duration time.Duration, err error)
+	// Called by `WatchDBStats` with the current pool stats of the database called `name`.
+	SetDBStats(name string, stats sql.DBStats)
+}
+
+// Reports every query to `Collector`, add it to `DBOptions.Hooks`.
+type MetricsHook struct {
+	Collector MetricsCollector
+}
+
+func (h MetricsHook) BeforeQuery(ctx context.Context, event *QueryEvent) context.Context {
+	return ctx
+}
+
+func (h MetricsHook) AfterQuery(ctx context.Context, event *QueryEvent) {
+	err := event.Err
+	if !IsQueryFailure(err) {
+		err = nil
+	}
+	h.Collector.ObserveQuery(event.Op, Fingerprint(event.Query), event.Duration, err)
+}
+
+// Reports the pool stats of `db` to `collector` right away and then every `interval`, until `ctx` is done. Run it in its own goroutine:
+//
+//	go WatchDBStats(ctx, "main", db, metrics, 15*time.Second)
+func WatchDBStats(ctx context.Context, name string, db interface{ Stats() sql.DBStats }, collector MetricsCollector, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		collector.SetDBStats(name, db.Stats())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+var (
+	// i.e. "(?, ?, ?)" in an IN list or a VALUES row
+	fingerprintList = regexp.MustCompile(`\( ?\?(?: ?, ?\?)+ ?\)`)
+	// i.e. "(?), (?)" for the rows of a batched insert
+	fingerprintRows = regexp.MustCompile(`\(\?\)(?: ?, ?\(\?\))+`)
+)
+
+// Normalizes `query` so that queries that only differ in their values share a fingerprint: literals and placeholders are replaced with `?`, lists of them are collapsed into `(?)` and whitespace is collapsed into single spaces.
+//
+//	SELECT * FROM t WHERE id IN ($1, $2) AND name = 'x'  ->  SELECT * FROM t WHERE id IN (?) AND name = ?
+func Fingerprint(query string) string {
+	b := strings.Builder{}
+	b.Grow(len(query))
+	space := false
+	isWord := func(ch byte) bool {
+		return ch == '_' || 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9'
+	}
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		if ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' {
+			space = true
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+
+		switch {
+		case ch == '\'':
+			// string literals, with '' escaping a quote
+			for i++; i < len(query); i++ {
+				if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			b.WriteByte('?')
+		case ch == '"':
+			// quoted identifiers are kept
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				b.WriteString(query[i:])
+				i = len(query)
+				continue
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 1
+		case ch == '$' && i+1 < len(query) && '0' <= query[i+1] && query[i+1] <= '9',
+			ch == ':' && i+1 < len(query) && isWord(query[i+1]) && (i == 0 || query[i-1] != ':'),
+			'0' <= ch && ch <= '9' && (i == 0 || !isWord(query[i-1])):
+			// placeholders and numbers
+			for i+1 < len(query) && (isWord(query[i+1]) || query[i+1] == '.') {
+				i++
+			}
+			b.WriteByte('?')
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	fingerprint := fingerprintList.ReplaceAllString(b.String(), "(?)")
+	return fingerprintRows.ReplaceAllString(fingerprint, "(?)")
+}
@@ -0,0 +1,103 @@
+package boilerplate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT * FROM t WHERE id = $1", "SELECT * FROM t WHERE id = ?"},
+		{"SELECT  *\n\tFROM t\nWHERE id = ? ", "SELECT * FROM t WHERE id = ?"},
+		{"SELECT * FROM t WHERE name = 'it''s' AND n > 10.5 AND t2.x = :x", "SELECT * FROM t WHERE name = ? AND n > ? AND t2.x = ?"},
+		{"SELECT * FROM t WHERE id IN ($1, $2, $3) AND a::int = 1", "SELECT * FROM t WHERE id IN (?) AND a::int = ?"},
+		{"SELECT * FROM t WHERE id IN (?,?)", "SELECT * FROM t WHERE id IN (?)"},
+		{"INSERT INTO t (a, b) VALUES ($1, $2), ($3, $4), ($5, $6) RETURNING *", "INSERT INTO t (a, b) VALUES (?) RETURNING *"},
+		{`SELECT "col 1" FROM "t1" WHERE "x" = 'y'`, `SELECT "col 1" FROM "t1" WHERE "x" = ?`},
+		{`SELECT "unterminated`, `SELECT "unterminated`},
+	}
+	for _, test := range tests {
+		if got := Fingerprint(test.query); got != test.expected {
+			t.Fatalf("Expected %q to be fingerprinted as %q, got %q", test.query, test.expected, got)
+		}
+	}
+}
+
+type observedQuery struct {
+	op          string
+	fingerprint string
+	err         error
+}
+
+type recordingCollector struct {
+	mu      sync.Mutex
+	queries []observedQuery
+	stats   []sql.DBStats
+}
+
+func (c *recordingCollector) ObserveQuery(op string, fingerprint string, duration time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queries = append(c.queries, observedQuery{op: op, fingerprint: fingerprint, err: err})
+}
+
+func (c *recordingCollector) SetDBStats(name string, stats sql.DBStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats = append(c.stats, stats)
+}
+
+func TestMetricsHook(t *testing.T) {
+	LoadDB(t)
+
+	collector := &recordingCollector{}
+	hooked := NewDB(db, DBOptions{Hooks: []QueryHook{MetricsHook{Collector: collector}}})
+
+	if _, err := Get[int](hooked, "SELECT ? + ?", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Get[int](hooked, "SELECT 1 WHERE 1 = ?", 0); !errors.Is(err, ErrNoRows) {
+		t.Fatalf("Expected ErrNoRows, got: %v", err)
+	}
+	if err := Exec(hooked, "SELECT * FROM missing_table"); err == nil {
+		t.Fatal("Expected an error for a missing table")
+	}
+
+	if len(collector.queries) != 3 {
+		t.Fatalf("Expected 3 queries to be observed, got %d", len(collector.queries))
+	}
+	if q := collector.queries[0]; q.op != "GET" || q.fingerprint != "SELECT ? + ?" || q.err != nil {
+		t.Fatalf("Unexpected observed query: %+v", q)
+	}
+	if q := collector.queries[1]; q.err != nil {
+		t.Fatalf("Expected no rows not to be observed as an error, got: %v", q.err)
+	}
+	if q := collector.queries[2]; q.op != "EXEC" || q.err == nil {
+		t.Fatalf("Expected the failed query to be observed with its error, got: %+v", q)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		WatchDBStats(ctx, "main", db, collector, time.Millisecond)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	<-done
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if len(collector.stats) < 2 {
+		t.Fatalf("Expected the stats to be reported periodically, got %d reports", len(collector.stats))
+	}
+	if collector.stats[0].OpenConnections == 0 {
+		t.Fatalf("Expected the pool stats to be reported, got %+v", collector.stats[0])
+	}
+}
@@ -0,0 +1,153 @@
+// Package otelboilerplate traces the execute helpers and transactions of go-sql-boilerplate with OpenTelemetry.
+//
+// Add the hook to a `boilerplate.DB`:
+//
+//	db := boilerplate.NewDB(sqlxDB, boilerplate.DBOptions{
+//		Hooks: []boilerplate.QueryHook{boilerplate.LogHook{}, otelboilerplate.New(otelboilerplate.Options{Driver: boilerplate.DriverPostgres})},
+//	})
+package otelboilerplate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	boilerplate "github.com/DonovanDiamond/go-sql-boilerplate"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/DonovanDiamond/go-sql-boilerplate/otelboilerplate"
+
+var (
+	// The helper that ran the query, i.e. GET or NAMED_EXEC_RET, see `boilerplate.QueryEvent.Op`.
+	HelperKey = attribute.Key("db.boilerplate.helper")
+	// Rows affected for EXEC and NAMED_EXEC, rows returned for everything else. Not set when unknown.
+	RowsAffectedKey = attribute.Key("db.rows_affected")
+	// The name of the savepoint of a nested transaction.
+	SavepointKey = attribute.Key("db.boilerplate.savepoint")
+)
+
+type Options struct {
+	// Defaults to the global tracer provider.
+	TracerProvider trace.TracerProvider
+	// Sets db.system on every span.
+	Driver boilerplate.Driver
+	// Leaves db.statement out of the spans, i.e. when queries contain literal values that should not be exported.
+	OmitStatement bool
+}
+
+// A `boilerplate.QueryHook` and `boilerplate.TxHook` that starts a span for every query and transaction. Queries run inside a transaction are children of its span.
+type Hook struct {
+	tracer trace.Tracer
+	opts   Options
+	system attribute.KeyValue
+}
+
+var (
+	_ boilerplate.QueryHook = (*Hook)(nil)
+	_ boilerplate.TxHook    = (*Hook)(nil)
+)
+
+func New(opts Options) *Hook {
+	provider := opts.TracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	h := &Hook{
+		tracer: provider.Tracer(instrumentationName),
+		opts:   opts,
+		system: semconv.DBSystemKey.String(string(opts.Driver)),
+	}
+	switch opts.Driver {
+	case boilerplate.DriverPostgres:
+		h.system = semconv.DBSystemPostgreSQL
+	case boilerplate.DriverSqlite:
+		h.system = semconv.DBSystemSqlite
+	}
+	return h
+}
+
+// Spans are stored under these keys as well as on the context, so a span started by another hook in between is not ended by mistake.
+type (
+	querySpanKey struct{}
+	txSpanKey    struct{}
+)
+
+func (h *Hook) BeforeQuery(ctx context.Context, event *boilerplate.QueryEvent) context.Context {
+	operation := operation(event.Query)
+	name := operation
+	if name == "" {
+		name = event.Op
+	}
+	attrs := []attribute.KeyValue{h.system, HelperKey.String(event.Op)}
+	if operation != "" {
+		attrs = append(attrs, semconv.DBOperation(operation))
+	}
+	if !h.opts.OmitStatement {
+		attrs = append(attrs, semconv.DBStatement(event.Query))
+	}
+	ctx, span := h.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return context.WithValue(ctx, querySpanKey{}, span)
+}
+
+func (h *Hook) AfterQuery(ctx context.Context, event *boilerplate.QueryEvent) {
+	span, ok := ctx.Value(querySpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	if event.RowsAffected >= 0 {
+		span.SetAttributes(RowsAffectedKey.Int64(event.RowsAffected))
+	}
+	if boilerplate.IsQueryFailure(event.Err) {
+		span.RecordError(event.Err)
+		span.SetStatus(codes.Error, event.Err.Error())
+	}
+	span.End()
+}
+
+func (h *Hook) BeforeTx(ctx context.Context, event *boilerplate.TxEvent) context.Context {
+	name := "TRANSACTION"
+	attrs := []attribute.KeyValue{h.system}
+	if event.Savepoint != "" {
+		name = "SAVEPOINT"
+		attrs = append(attrs, SavepointKey.String(event.Savepoint))
+	}
+	ctx, span := h.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return context.WithValue(ctx, txSpanKey{}, span)
+}
+
+func (h *Hook) AfterTx(ctx context.Context, event *boilerplate.TxEvent) {
+	span, ok := ctx.Value(txSpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	// the statement that ended the transaction, i.e. COMMIT or ROLLBACK
+	span.SetAttributes(semconv.DBOperation(event.Op))
+	switch {
+	case event.Err != nil:
+		span.RecordError(event.Err)
+		span.SetStatus(codes.Error, event.Err.Error())
+	case event.Cause != nil:
+		if err, ok := event.Cause.(error); ok {
+			span.RecordError(err)
+		}
+		span.SetStatus(codes.Error, fmt.Sprint(event.Cause))
+	}
+	span.End()
+}
+
+// Returns the first keyword of `query`, i.e. SELECT or INSERT, or an empty string if it does not start with a word.
+func operation(query string) string {
+	query = strings.TrimSpace(query)
+	end := strings.IndexFunc(query, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z')
+	})
+	if end < 0 {
+		end = len(query)
+	}
+	return strings.ToUpper(query[:end])
+}
@@ -0,0 +1,135 @@
+package otelboilerplate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	boilerplate "github.com/DonovanDiamond/go-sql-boilerplate"
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestHook(t *testing.T) {
+	sqliteDB, err := boilerplate.Connect(boilerplate.DriverSqlite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is a separate database
+	sqliteDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqliteDB.Close() })
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	db := boilerplate.NewDB(sqliteDB, boilerplate.DBOptions{
+		Hooks: []boilerplate.QueryHook{New(Options{TracerProvider: provider, Driver: boilerplate.DriverSqlite})},
+	})
+
+	attrs := func(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+		result := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes {
+			result[kv.Key] = kv.Value
+		}
+		return result
+	}
+
+	t.Run("queries", func(t *testing.T) {
+		exporter.Reset()
+		if err := boilerplate.Exec(db, "CREATE TABLE t (id INTEGER PRIMARY KEY, value TEXT NOT NULL UNIQUE)"); err != nil {
+			t.Fatal(err)
+		}
+		if err := boilerplate.NamedExec(db, nil, "INSERT INTO t (value) VALUES (:value)", map[string]any{"value": "a"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := boilerplate.Select[[]string](db, "SELECT value FROM t"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := boilerplate.Get[string](db, "SELECT value FROM t WHERE id = ?", 100); !errors.Is(err, boilerplate.ErrNoRows) {
+			t.Fatalf("Expected ErrNoRows, got: %v", err)
+		}
+		if err := boilerplate.Exec(db, "INSERT INTO t (value) VALUES (?)", "a"); !errors.Is(err, boilerplate.ErrUniqueViolation) {
+			t.Fatalf("Expected a unique violation, got: %v", err)
+		}
+
+		spans := exporter.GetSpans()
+		if len(spans) != 5 {
+			t.Fatalf("Expected 5 spans, got %d", len(spans))
+		}
+		expected := []struct {
+			name      string
+			helper    string
+			statement string
+			rows      int64
+			failed    bool
+		}{
+			{"CREATE", "EXEC", "CREATE TABLE t (id INTEGER PRIMARY KEY, value TEXT NOT NULL UNIQUE)", 0, false},
+			{"INSERT", "NAMED_EXEC", "INSERT INTO t (value) VALUES (:value)", 1, false},
+			{"SELECT", "SELECT", "SELECT value FROM t", 1, false},
+			{"SELECT", "GET", "SELECT value FROM t WHERE id = ?", 0, false},
+			{"INSERT", "EXEC", "INSERT INTO t (value) VALUES (?)", -1, true},
+		}
+		for i, e := range expected {
+			span := spans[i]
+			a := attrs(span)
+			if span.Name != e.name || a["db.system"].AsString() != "sqlite" || a["db.operation"].AsString() != e.name || a["db.statement"].AsString() != e.statement || a[HelperKey].AsString() != e.helper {
+				t.Fatalf("Unexpected span %d: %s %v", i, span.Name, span.Attributes)
+			}
+			if rows, ok := a[RowsAffectedKey]; (e.rows >= 0) != ok || ok && rows.AsInt64() != e.rows {
+				t.Fatalf("Expected span %d to have %d rows affected, got %v", i, e.rows, rows)
+			}
+			if failed := span.Status.Code == codes.Error; failed != e.failed || failed && len(span.Events) == 0 {
+				t.Fatalf("Expected span %d failed to be %t, got %v", i, e.failed, span.Status)
+			}
+		}
+	})
+
+	t.Run("transactions", func(t *testing.T) {
+		exporter.Reset()
+		errExpected := errors.New("expected")
+		err := boilerplate.WithTx(context.Background(), db, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+			if _, err := boilerplate.GetContext[int](ctx, db, "SELECT COUNT(*) FROM t"); err != nil {
+				return err
+			}
+			_ = boilerplate.WithTx(ctx, db, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+				return errExpected
+			})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		spans := exporter.GetSpans()
+		if len(spans) != 3 {
+			t.Fatalf("Expected 3 spans, got %d", len(spans))
+		}
+		query, savepoint, tx := spans[0], spans[1], spans[2]
+		if tx.Name != "TRANSACTION" || attrs(tx)["db.operation"].AsString() != "COMMIT" || tx.Status.Code == codes.Error {
+			t.Fatalf("Expected a committed transaction span, got %s %v %v", tx.Name, tx.Attributes, tx.Status)
+		}
+		if savepoint.Name != "SAVEPOINT" || attrs(savepoint)["db.operation"].AsString() != "ROLLBACK TO SAVEPOINT" || savepoint.Status.Code != codes.Error || savepoint.Status.Description != "expected" {
+			t.Fatalf("Expected a rolled back savepoint span, got %s %v %v", savepoint.Name, savepoint.Attributes, savepoint.Status)
+		}
+		for _, child := range []tracetest.SpanStub{query, savepoint} {
+			if child.Parent.SpanID() != tx.SpanContext.SpanID() {
+				t.Fatalf("Expected %s to be a child of the transaction span", child.Name)
+			}
+		}
+	})
+
+	t.Run("omit statement", func(t *testing.T) {
+		exporter.Reset()
+		db := boilerplate.NewDB(sqliteDB, boilerplate.DBOptions{
+			Hooks: []boilerplate.QueryHook{New(Options{TracerProvider: provider, Driver: boilerplate.DriverSqlite, OmitStatement: true})},
+		})
+		if _, err := boilerplate.Get[int](db, "SELECT 1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := attrs(exporter.GetSpans()[0])["db.statement"]; ok {
+			t.Fatal("Expected the statement to be left out")
+		}
+	})
+}
@@ -0,0 +1,207 @@
+package boilerplate
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The same buckets as the Prometheus client's defaults, in seconds.
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type PrometheusMetricsOptions struct {
+	// Prefix of every metric name, defaults to "db".
+	Namespace string
+	// Upper bounds of the latency histogram buckets in seconds, defaults to `DefaultLatencyBuckets`.
+	Buckets []float64
+	// Queries with a new fingerprint are counted under the fingerprint "other" once this many are tracked, so unexpected queries cannot grow the metrics without bounds. Defaults to 1000.
+	MaxFingerprints int
+}
+
+// A `MetricsCollector` that serves its metrics in the Prometheus text format as an `http.Handler`:
+//
+//	metrics := NewPrometheusMetrics(PrometheusMetricsOptions{})
+//	db := NewDB(sqlxDB, DBOptions{Hooks: []QueryHook{LogHook{}, MetricsHook{Collector: metrics}}})
+//	go WatchDBStats(ctx, "main", db, metrics, 15*time.Second)
+//	http.Handle("/metrics", metrics)
+//
+// It serves:
+//
+//   - <namespace>_queries_total and <namespace>_query_errors_total, counters by op and query fingerprint
+//   - <namespace>_query_duration_seconds, a histogram by op and query fingerprint
+//   - <namespace>_connections_* and the other `sql.DBStats` by db name
+type PrometheusMetrics struct {
+	opts PrometheusMetricsOptions
+
+	mu      sync.Mutex
+	queries map[queryKey]*queryMetrics
+	dbStats map[string]sql.DBStats
+}
+
+type queryKey struct {
+	op          string
+	fingerprint string
+}
+
+type queryMetrics struct {
+	count  uint64
+	errors uint64
+	// Cumulative counts are computed when served, these are the counts per bucket with the last one for +Inf.
+	buckets []uint64
+	sum     float64
+}
+
+var _ MetricsCollector = (*PrometheusMetrics)(nil)
+
+func NewPrometheusMetrics(opts PrometheusMetricsOptions) *PrometheusMetrics {
+	if opts.Namespace == "" {
+		opts.Namespace = "db"
+	}
+	if len(opts.Buckets) == 0 {
+		opts.Buckets = DefaultLatencyBuckets
+	}
+	opts.Buckets = slices.Sorted(slices.Values(opts.Buckets))
+	if opts.MaxFingerprints <= 0 {
+		opts.MaxFingerprints = 1000
+	}
+	return &PrometheusMetrics{
+		opts:    opts,
+		queries: map[queryKey]*queryMetrics{},
+		dbStats: map[string]sql.DBStats{},
+	}
+}
+
+func (m *PrometheusMetrics) ObserveQuery(op string, fingerprint string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := queryKey{op: op, fingerprint: fingerprint}
+	q, ok := m.queries[key]
+	if !ok {
+		if len(m.queries) >= m.opts.MaxFingerprints {
+			key.fingerprint = "other"
+			q = m.queries[key]
+		}
+		if q == nil {
+			q = &queryMetrics{buckets: make([]uint64, len(m.opts.Buckets)+1)}
+			m.queries[key] = q
+		}
+	}
+
+	seconds := duration.Seconds()
+	q.count++
+	if err != nil {
+		q.errors++
+	}
+	q.sum += seconds
+	i, _ := slices.BinarySearch(m.opts.Buckets, seconds)
+	q.buckets[i]++
+}
+
+func (m *PrometheusMetrics) SetDBStats(name string, stats sql.DBStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dbStats[name] = stats
+}
+
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	m.write(bw)
+	_ = bw.Flush()
+}
+
+// Writes every metric in the Prometheus text format, sorted by name and labels.
+func (m *PrometheusMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ns := m.opts.Namespace
+	keys := make([]queryKey, 0, len(m.queries))
+	for key := range m.queries {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b queryKey) int {
+		if c := strings.Compare(a.op, b.op); c != 0 {
+			return c
+		}
+		return strings.Compare(a.fingerprint, b.fingerprint)
+	})
+	labels := func(key queryKey) string {
+		return fmt.Sprintf(`op="%s",query="%s"`, escapeLabel(key.op), escapeLabel(key.fingerprint))
+	}
+
+	writeHeader(w, ns+"_queries_total", "counter", "Queries run through the execute helpers.")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s_queries_total{%s} %d\n", ns, labels(key), m.queries[key].count)
+	}
+	writeHeader(w, ns+"_query_errors_total", "counter", "Queries that returned an error, other than no rows.")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s_query_errors_total{%s} %d\n", ns, labels(key), m.queries[key].errors)
+	}
+	writeHeader(w, ns+"_query_duration_seconds", "histogram", "Latency of the queries run through the execute helpers.")
+	for _, key := range keys {
+		q := m.queries[key]
+		cumulative := uint64(0)
+		for i, count := range q.buckets {
+			cumulative += count
+			le := "+Inf"
+			if i < len(m.opts.Buckets) {
+				le = formatFloat(m.opts.Buckets[i])
+			}
+			fmt.Fprintf(w, "%s_query_duration_seconds_bucket{%s,le=\"%s\"} %d\n", ns, labels(key), le, cumulative)
+		}
+		fmt.Fprintf(w, "%s_query_duration_seconds_sum{%s} %s\n", ns, labels(key), formatFloat(q.sum))
+		fmt.Fprintf(w, "%s_query_duration_seconds_count{%s} %d\n", ns, labels(key), q.count)
+	}
+
+	names := make([]string, 0, len(m.dbStats))
+	for name := range m.dbStats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, stat := range dbStatMetrics {
+		writeHeader(w, ns+"_"+stat.name, stat.kind, stat.help)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s_%s{db=\"%s\"} %s\n", ns, stat.name, escapeLabel(name), formatFloat(stat.value(m.dbStats[name])))
+		}
+	}
+}
+
+var dbStatMetrics = []struct {
+	name  string
+	kind  string
+	help  string
+	value func(sql.DBStats) float64
+}{
+	{"connections_max_open", "gauge", "Maximum number of open connections.", func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+	{"connections_open", "gauge", "Open connections, in use and idle.", func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+	{"connections_in_use", "gauge", "Connections currently in use.", func(s sql.DBStats) float64 { return float64(s.InUse) }},
+	{"connections_idle", "gauge", "Idle connections.", func(s sql.DBStats) float64 { return float64(s.Idle) }},
+	{"connections_wait_total", "counter", "Times a connection had to be waited for.", func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+	{"connections_wait_seconds_total", "counter", "Time spent waiting for a connection.", func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+	{"connections_max_idle_closed_total", "counter", "Connections closed because of SetMaxIdleConns.", func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }},
+	{"connections_max_idle_time_closed_total", "counter", "Connections closed because of SetConnMaxIdleTime.", func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }},
+	{"connections_max_lifetime_closed_total", "counter", "Connections closed because of SetConnMaxLifetime.", func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }},
+}
+
+func writeHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
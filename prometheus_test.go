@@ -0,0 +1,57 @@
+package boilerplate
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	metrics := NewPrometheusMetrics(PrometheusMetricsOptions{Namespace: "app_db", Buckets: []float64{1, 0.1}, MaxFingerprints: 2})
+
+	metrics.ObserveQuery("GET", "SELECT * FROM t WHERE id = ?", 50*time.Millisecond, nil)
+	metrics.ObserveQuery("GET", "SELECT * FROM t WHERE id = ?", 500*time.Millisecond, errors.New("failed"))
+	metrics.ObserveQuery("EXEC", `UPDATE t SET "a" = ?`+"\n", 2*time.Second, nil)
+	metrics.ObserveQuery("SELECT", "SELECT * FROM a", time.Millisecond, nil)
+	metrics.ObserveQuery("SELECT", "SELECT * FROM b", time.Millisecond, nil)
+	metrics.SetDBStats("main", sql.DBStats{MaxOpenConnections: 10, OpenConnections: 3, InUse: 1, Idle: 2, WaitDuration: 1500 * time.Millisecond})
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("Expected the Prometheus text format, got %q", recorder.Header().Get("Content-Type"))
+	}
+	body, _ := io.ReadAll(recorder.Body)
+
+	expected := []string{
+		"# TYPE app_db_queries_total counter",
+		`app_db_queries_total{op="EXEC",query="UPDATE t SET \"a\" = ?\n"} 1`,
+		`app_db_queries_total{op="GET",query="SELECT * FROM t WHERE id = ?"} 2`,
+		`app_db_queries_total{op="SELECT",query="other"} 2`,
+		`app_db_query_errors_total{op="GET",query="SELECT * FROM t WHERE id = ?"} 1`,
+		"# TYPE app_db_query_duration_seconds histogram",
+		`app_db_query_duration_seconds_bucket{op="GET",query="SELECT * FROM t WHERE id = ?",le="0.1"} 1`,
+		`app_db_query_duration_seconds_bucket{op="GET",query="SELECT * FROM t WHERE id = ?",le="1"} 2`,
+		`app_db_query_duration_seconds_bucket{op="GET",query="SELECT * FROM t WHERE id = ?",le="+Inf"} 2`,
+		`app_db_query_duration_seconds_sum{op="GET",query="SELECT * FROM t WHERE id = ?"} 0.55`,
+		`app_db_query_duration_seconds_count{op="GET",query="SELECT * FROM t WHERE id = ?"} 2`,
+		`app_db_query_duration_seconds_bucket{op="EXEC",query="UPDATE t SET \"a\" = ?\n",le="1"} 0`,
+		"# TYPE app_db_connections_open gauge",
+		`app_db_connections_max_open{db="main"} 10`,
+		`app_db_connections_open{db="main"} 3`,
+		`app_db_connections_in_use{db="main"} 1`,
+		`app_db_connections_idle{db="main"} 2`,
+		`app_db_connections_wait_seconds_total{db="main"} 1.5`,
+	}
+	lines := strings.Split(string(body), "\n")
+	for _, line := range expected {
+		if !slices.Contains(lines, line) {
+			t.Fatalf("Expected the line %q, got:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package boilerplate
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Logged in place of a redacted value.
+const Redacted = "[REDACTED]"
+
+// Decides which values are redacted from the logs of `LogHook`.
+//
+// Struct fields tagged `log:"redact"` are always redacted, both in named args and in results.
+type redactor struct {
+	columns []string
+}
+
+func (r redactor) redactColumn(name string) bool {
+	return slices.ContainsFunc(r.columns, func(c string) bool { return strings.EqualFold(c, name) })
+}
+
+// Redacts the positional `args` of `query` that are compared with, or inserted into, one of the redacted columns.
+func (r redactor) positionalArgs(query string, args []any) []any {
+	if len(r.columns) == 0 || len(args) == 0 {
+		return args
+	}
+	var redacted []any
+	for _, i := range placeholderColumns(query, r.redactColumn) {
+		if i >= len(args) {
+			continue
+		}
+		if redacted == nil {
+			redacted = append([]any(nil), args...)
+		}
+		redacted[i] = Redacted
+	}
+	if redacted == nil {
+		return args
+	}
+	return redacted
+}
+
+// Redacts the tagged fields, and the fields and map keys of redacted columns, of every value in `v`, i.e. the named args or the result of a query. Values with nothing to redact are returned as is.
+//
+// Structs with something to redact are returned as a map by column name.
+func (r redactor) value(v any) any {
+	if v == nil {
+		return nil
+	}
+	rv, ok := r.reflectValue(reflect.ValueOf(v))
+	if !ok {
+		return v
+	}
+	return rv.Interface()
+}
+
+// Returns the redacted copy of `v`, or false if nothing in it had to be redacted.
+func (r redactor) reflectValue(v reflect.Value) (reflect.Value, bool) {
+	if v.Type().Implements(reflect.TypeFor[driver.Valuer]()) {
+		return v, false
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return v, false
+		}
+		return r.reflectValue(v.Elem())
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return v, false
+		}
+		var out []any
+		for i := range v.Len() {
+			elem, ok := r.reflectValue(v.Index(i))
+			if ok && out == nil {
+				out = make([]any, v.Len())
+				for j := range i {
+					out[j] = v.Index(j).Interface()
+				}
+			}
+			if out != nil {
+				out[i] = elem.Interface()
+			}
+		}
+		if out == nil {
+			return v, false
+		}
+		return reflect.ValueOf(out), true
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return v, false
+		}
+		var out map[string]any
+		for _, key := range v.MapKeys() {
+			var elem any = Redacted
+			ok := r.redactColumn(key.String())
+			if !ok {
+				var rv reflect.Value
+				rv, ok = r.reflectValue(v.MapIndex(key))
+				elem = rv.Interface()
+			}
+			if ok && out == nil {
+				out = make(map[string]any, v.Len())
+			}
+			if ok {
+				out[key.String()] = elem
+			}
+		}
+		if out == nil {
+			return v, false
+		}
+		for _, key := range v.MapKeys() {
+			if _, ok := out[key.String()]; !ok {
+				out[key.String()] = v.MapIndex(key).Interface()
+			}
+		}
+		return reflect.ValueOf(out), true
+
+	case reflect.Struct:
+		fields := logFields(v.Type())
+		redact := false
+		for _, f := range fields {
+			if f.redact || r.redactColumn(f.name) {
+				redact = true
+				break
+			}
+		}
+		if !redact {
+			return v, false
+		}
+		out := make(map[string]any, len(fields))
+		for _, f := range fields {
+			if f.redact || r.redactColumn(f.name) {
+				out[f.name] = Redacted
+				continue
+			}
+			field, err := v.FieldByIndexErr(f.index)
+			if err != nil {
+				// a nil embedded pointer
+				continue
+			}
+			if rv, ok := r.reflectValue(field); ok {
+				out[f.name] = rv.Interface()
+			} else {
+				out[f.name] = field.Interface()
+			}
+		}
+		return reflect.ValueOf(out), true
+	}
+	return v, false
+}
+
+type logField struct {
+	// The column name, from the 'db' tag or the lowercased field name like sqlx.
+	name   string
+	index  []int
+	redact bool
+}
+
+var logFieldsCache sync.Map
+
+// Returns the exported fields of the struct type `t`, with embedded structs flattened.
+func logFields(t reflect.Type) []logField {
+	if fields, ok := logFieldsCache.Load(t); ok {
+		return fields.([]logField)
+	}
+	fields := []logField{}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("db"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields = append(fields, logField{name: name, index: f.Index, redact: f.Tag.Get("log") == "redact"})
+	}
+	logFieldsCache.Store(t, fields)
+	return fields
+}
+
+var (
+	placeholderPattern = regexp.MustCompile(`\$(\d+)|\?`)
+	// i.e. "col = $1" or "t.col LIKE ?"
+	comparedColumnPattern = regexp.MustCompile(`(?i)([\w."]+)\s*(?:=|<>|!=|<=|>=|<|>|\s(?:NOT\s+)?I?LIKE\s)\s*(\$\d+|\?)`)
+	// i.e. "col IN ($1, $2)", every placeholder in the list is bound to the column
+	inColumnPattern = regexp.MustCompile(`(?i)([\w."]+)\s+(?:NOT\s+)?IN\s*\(([^)]*)\)`)
+	insertPattern   = regexp.MustCompile(`(?is)INSERT\s+INTO\s+[\w."]+\s*\(([^)]*)\)\s*VALUES\s*(.*)`)
+	tuplePattern    = regexp.MustCompile(`\(([^)]*)\)`)
+)
+
+// Returns the indexes of the args bound to the placeholders of `query` that are compared with, or inserted into, a column for which `match` returns true.
+//
+// The query is matched with regular expressions rather than parsed, so this only finds the common `col = $1`, `col IN ($1, $2)` and `INSERT INTO t (col) VALUES ($1)` forms.
+func placeholderColumns(query string, match func(column string) bool) []int {
+	// the arg index of every placeholder by its offset, "?" placeholders are numbered in order
+	argIndex := map[int]int{}
+	next := 0
+	for _, m := range placeholderPattern.FindAllStringSubmatchIndex(query, -1) {
+		if m[2] >= 0 {
+			n, _ := strconv.Atoi(query[m[2]:m[3]])
+			argIndex[m[0]] = n - 1
+		} else {
+			argIndex[m[0]] = next
+			next++
+		}
+	}
+
+	matchColumn := func(col string) bool {
+		if i := strings.LastIndex(col, "."); i >= 0 {
+			col = col[i+1:]
+		}
+		return match(strings.Trim(col, `"`))
+	}
+
+	indexes := []int{}
+	for _, m := range comparedColumnPattern.FindAllStringSubmatchIndex(query, -1) {
+		if matchColumn(query[m[2]:m[3]]) {
+			indexes = append(indexes, argIndex[m[4]])
+		}
+	}
+	for _, m := range inColumnPattern.FindAllStringSubmatchIndex(query, -1) {
+		if !matchColumn(query[m[2]:m[3]]) {
+			continue
+		}
+		for _, p := range placeholderPattern.FindAllStringIndex(query[m[4]:m[5]], -1) {
+			indexes = append(indexes, argIndex[m[4]+p[0]])
+		}
+	}
+
+	if m := insertPattern.FindStringSubmatchIndex(query); m != nil {
+		cols := strings.Split(query[m[2]:m[3]], ",")
+		valuesStart := m[4]
+		for _, tuple := range tuplePattern.FindAllStringSubmatchIndex(query[valuesStart:], -1) {
+			offset := valuesStart + tuple[2]
+			for i, value := range strings.Split(query[offset:valuesStart+tuple[3]], ",") {
+				start := offset + len(value) - len(strings.TrimLeft(value, " \t\n"))
+				offset += len(value) + 1
+				if i >= len(cols) || !match(strings.Trim(strings.TrimSpace(cols[i]), `"`)) {
+					continue
+				}
+				if index, ok := argIndex[start]; ok {
+					indexes = append(indexes, index)
+				}
+			}
+		}
+	}
+	return indexes
+}
+
+// Returns `v` as JSON, cut off after `maxSize` bytes. The bool reports whether it was truncated.
+func truncatedJSON(v any, maxSize int) (string, bool, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", false, err
+	}
+	if maxSize <= 0 || len(b) <= maxSize {
+		return string(b), false, nil
+	}
+	return strings.ToValidUTF8(string(b[:maxSize]), ""), true, nil
+}
@@ -0,0 +1,140 @@
+package boilerplate
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestPlaceholderColumns(t *testing.T) {
+	isSecret := func(col string) bool { return col == "password" || col == "token" }
+
+	cases := []struct {
+		query    string
+		expected []int
+	}{
+		{"SELECT * FROM users WHERE email = $1 AND password = $2", []int{1}},
+		{"SELECT * FROM users WHERE email = ? AND u.password=?", []int{1}},
+		{`UPDATE users SET "token" = $2 WHERE id = $1`, []int{1}},
+		{"SELECT * FROM users WHERE token LIKE $1 OR token IN ($2, $3)", []int{0, 1, 2}},
+		{"SELECT * FROM users WHERE email IN (?, ?) AND u.token NOT IN (?, ?, ?)", []int{2, 3, 4}},
+		{"INSERT INTO users (email, password, token) VALUES ($1, $2, $3), ($4, $5, $6)", []int{1, 2, 4, 5}},
+		{"INSERT INTO users (email, password) VALUES (?, ?) RETURNING *", []int{1}},
+		{"INSERT INTO users (password, email) VALUES ('x', $1)", []int{}},
+		{"SELECT * FROM users WHERE email = $1", []int{}},
+	}
+	for _, c := range cases {
+		AssertStructEqual(t, c.expected, placeholderColumns(c.query, isSecret), c.query)
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	LoadDB(t)
+
+	type Table struct {
+		ID       int    `db:"id" dbtype:"BIGSERIAL NOT NULL PRIMARY KEY"`
+		Email    string `db:"email" dbtype:"TEXT NOT NULL"`
+		Password string `db:"password" dbtype:"TEXT NOT NULL" log:"redact"`
+		Token    string `db:"token" dbtype:"TEXT NOT NULL"`
+	}
+	queries := GenerateQueries(GenerateQueriesOptions{
+		TableName:          "table_redaction",
+		Model:              Table{},
+		AutoGeneratingCols: []string{"id"},
+		PrimaryKeys:        []string{"id"},
+		Driver:             DriverSqlite,
+	})
+
+	t.Cleanup(func() {
+		_ = Exec(db, queries.DropTable)
+	})
+
+	err := Exec(db, queries.CreateTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.Buffer{}
+	logger := zerolog.New(&buf).Level(zerolog.TraceLevel)
+	hooked := NewDB(db, DBOptions{Logger: &logger, RedactColumns: []string{"Token"}})
+
+	// the entries logged since the last call
+	entries := func() []map[string]any {
+		defer buf.Reset()
+		result := []map[string]any{}
+		for line := range strings.SplitSeq(strings.TrimSpace(buf.String()), "\n") {
+			entry := map[string]any{}
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatal(err)
+			}
+			result = append(result, entry)
+		}
+		return result
+	}
+
+	t.Run("named args and results", func(t *testing.T) {
+		row := Table{Email: "a@example.com", Password: "hunter2", Token: "secret-token"}
+		if err := NamedExecReturning(hooked, &row, queries.Insert, &row); err != nil {
+			t.Fatal(err)
+		}
+		if err := NamedExec(hooked, nil, "UPDATE table_redaction SET token = :token WHERE id = :id", map[string]any{"token": "new-token", "id": row.ID}); err != nil {
+			t.Fatal(err)
+		}
+
+		logged := entries()
+		if len(logged) != 2 {
+			t.Fatalf("Expected 2 log lines, got %d", len(logged))
+		}
+		// the row is both the arg and the dest, so it already has its id by the time it is logged
+		AssertStructEqual(t, []any{map[string]any{"id": float64(row.ID), "email": "a@example.com", "password": Redacted, "token": Redacted}}, logged[0]["args"], "Expected the tagged field and column to be redacted from the args")
+		AssertStructEqual(t, map[string]any{"id": float64(row.ID), "email": "a@example.com", "password": Redacted, "token": Redacted}, logged[0]["result"], "Expected the result to be redacted")
+		AssertStructEqual(t, []any{map[string]any{"id": float64(row.ID), "token": Redacted}}, logged[1]["args"], "Expected the map key to be redacted")
+		if row.Password != "hunter2" || row.Token != "secret-token" {
+			t.Fatalf("Expected the scanned row not to be modified, got %+v", row)
+		}
+	})
+
+	t.Run("positional args", func(t *testing.T) {
+		if _, err := Get[int](hooked, "SELECT id FROM table_redaction WHERE email = $1 AND token = $2", "a@example.com", "new-token"); err != nil {
+			t.Fatal(err)
+		}
+
+		logged := entries()
+		AssertStructEqual(t, []any{"a@example.com", Redacted}, logged[0]["args"], "Expected the arg compared with the column to be redacted")
+
+		// the slice is expanded into one placeholder per value before the hooks run
+		if _, err := Select[[]int](hooked, "SELECT id FROM table_redaction WHERE email = ? AND token IN (?)", "a@example.com", []string{"secret-a", "secret-b", "secret-c"}); err != nil {
+			t.Fatal(err)
+		}
+		logged = entries()
+		AssertStructEqual(t, []any{"a@example.com", Redacted, Redacted, Redacted}, logged[0]["args"], "Expected every value of the IN list to be redacted")
+	})
+
+	t.Run("truncation", func(t *testing.T) {
+		hooked := NewDB(db, DBOptions{Logger: &logger, MaxResultSize: 64})
+		for range 3 {
+			if err := Exec(hooked, "INSERT INTO table_redaction (email, password, token) VALUES ($1, 'x', 'y')", strings.Repeat("a", 20)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := Select[[]string](hooked, "SELECT email FROM table_redaction"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Get[int](hooked, "SELECT COUNT(*) FROM table_redaction"); err != nil {
+			t.Fatal(err)
+		}
+
+		logged := entries()
+		selected := logged[len(logged)-2]
+		if result, _ := selected["result"].(string); len(result) != 64 || selected["result_truncated"] != true {
+			t.Fatalf("Expected the result to be truncated to 64 bytes, got: %v", selected)
+		}
+		counted := logged[len(logged)-1]
+		if counted["result"] != float64(4) || counted["result_truncated"] != nil {
+			t.Fatalf("Expected a small result to be logged as is, got: %v", counted)
+		}
+	})
+}
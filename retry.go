@@ -0,0 +1,168 @@
+package boilerplate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Controls how `Retry`, `WithTxRetry` and `ConnectWithOptions` retry transient errors.
+//
+// Zero fields fall back to the matching field of `DefaultRetryPolicy`.
+type RetryPolicy struct {
+	// Total number of attempts, including the first one.
+	MaxAttempts int
+	// Wait before the first retry, multiplied by `Multiplier` for every retry after that, up to `MaxBackoff`.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Fraction of the backoff that is randomised, so concurrent callers do not retry in lockstep. 0.2 waits anywhere between 80% and 120% of the backoff, a negative value disables jitter.
+	Jitter float64
+	// Driver the errors come from, used to pick the classifier when `Retryable` is nil. Leave empty to accept the retryable errors of every driver.
+	Driver Driver
+	// Decides whether an error is worth retrying. Defaults to `IsRetryable` for `Driver`.
+	Retryable func(err error) bool
+	// Logs every retry at trace level as RETRY, defaults to the global zerolog logger.
+	Logger *zerolog.Logger
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 10 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	return p.withDefaultsFrom(DefaultRetryPolicy)
+}
+
+// Fills the zero fields of `p` from `base`.
+func (p RetryPolicy) withDefaultsFrom(base RetryPolicy) RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = base.MaxAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = base.InitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = base.MaxBackoff
+	}
+	if p.Multiplier <= 0 {
+		p.Multiplier = base.Multiplier
+	}
+	if p.Jitter == 0 {
+		p.Jitter = base.Jitter
+	}
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	}
+	if p.Retryable == nil {
+		p.Retryable = base.Retryable
+	}
+	if p.Logger == nil {
+		p.Logger = base.Logger
+	}
+	if p.Logger == nil {
+		p.Logger = &log.Logger
+	}
+	if p.Retryable == nil {
+		driver := p.Driver
+		p.Retryable = func(err error) bool { return IsRetryable(driver, err) }
+	}
+	return p
+}
+
+// Returns how long to wait after the given failed attempt, starting at 1.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	d = min(d, float64(p.MaxBackoff))
+	d *= 1 - p.Jitter + 2*p.Jitter*rand.Float64()
+	return time.Duration(d)
+}
+
+// Reports whether `err` is a transient error from `driver` that is likely to succeed when retried:
+//
+//   - postgres: serialization failures (40001) and deadlocks (40P01)
+//   - sqlite: SQLITE_BUSY and SQLITE_LOCKED, i.e. "database is locked"
+//
+// An empty `driver` checks for the retryable errors of every driver.
+func IsRetryable(driver Driver, err error) bool {
+	if err == nil {
+		return false
+	}
+	switch driver {
+	case DriverPostgres:
+		return isRetryablePostgres(err)
+	case DriverSqlite:
+		return isRetryableSqlite(err)
+	default:
+		return isRetryablePostgres(err) || isRetryableSqlite(err)
+	}
+}
+
+func isRetryablePostgres(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == "40001" || pqErr.Code == "40P01"
+}
+
+func isRetryableSqlite(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	// extended result codes keep the primary code in the lowest byte
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// Runs `fn` until it succeeds, returns an error `policy` does not consider retryable, or runs out of attempts, waiting with exponential backoff in between. The last error is returned.
+//
+// If `ctx` already carries a transaction `fn` is only run once, as a failed statement usually aborts the whole transaction, so only the outermost transaction can be retried.
+func Retry(ctx context.Context, policy RetryPolicy, fn func(ctx context.Context) error) (err error) {
+	if _, ok := TxFromContext(ctx); ok {
+		return fn(ctx)
+	}
+
+	policy = policy.withDefaults()
+	for attempt := 1; ; attempt++ {
+		err = fn(ctx)
+		if err == nil || attempt >= policy.MaxAttempts || !policy.Retryable(err) {
+			return err
+		}
+
+		backoff := policy.backoff(attempt)
+		policy.Logger.Trace().Err(err).Int("attempt", attempt).Dur("backoff", backoff).Msg("RETRY")
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// Runs `WithTx`, retrying the whole transaction according to `policy`. See `Retry`.
+//
+// `fn` may run several times, so it should not have side effects outside the transaction.
+func WithTxRetry(ctx context.Context, db Querier, policy RetryPolicy, opts *sql.TxOptions, fn func(ctx context.Context, tx *sqlx.Tx) error) error {
+	return Retry(ctx, policy, func(ctx context.Context) error {
+		return WithTx(ctx, db, opts, fn)
+	})
+}
@@ -0,0 +1,205 @@
+package boilerplate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog"
+)
+
+// Opens two separate handles on the same sqlite file, and holds a write lock on the first one until the returned func is called.
+func lockedSqlite(t *testing.T) (locker *sqlx.DB, other *sqlx.DB, unlock func()) {
+	path := filepath.Join(t.TempDir(), "locked.db")
+
+	locker, err := Connect(DriverSqlite, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = locker.Close() })
+	other, err = Connect(DriverSqlite, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = other.Close() })
+
+	err = Exec(locker, "CREATE TABLE locked (id INTEGER PRIMARY KEY AUTOINCREMENT, value TEXT)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := locker.Connx(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.ExecContext(context.Background(), "BEGIN IMMEDIATE")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var once sync.Once
+	unlock = func() {
+		once.Do(func() {
+			// the commit itself is busy while the other handle holds a read lock
+			err := Retry(context.Background(), RetryPolicy{MaxAttempts: 100, InitialBackoff: time.Millisecond}, func(ctx context.Context) error {
+				_, err := conn.ExecContext(ctx, "COMMIT")
+				return err
+			})
+			if err != nil {
+				t.Error(err)
+			}
+			_ = conn.Close()
+		})
+	}
+	t.Cleanup(unlock)
+	return locker, other, unlock
+}
+
+func TestIsRetryable(t *testing.T) {
+	_, other, _ := lockedSqlite(t)
+
+	err := Exec(other, "INSERT INTO locked (value) VALUES ($1)", "busy")
+	if err == nil {
+		t.Fatal("Expected insert into a locked database to fail, but got nil.")
+	}
+	if !IsRetryable(DriverSqlite, err) {
+		t.Fatalf("Expected busy error to be retryable for sqlite: %v", err)
+	}
+	if !IsRetryable("", err) {
+		t.Fatalf("Expected busy error to be retryable without a driver: %v", err)
+	}
+	if IsRetryable(DriverPostgres, err) {
+		t.Fatalf("Expected sqlite busy error not to be retryable for postgres: %v", err)
+	}
+
+	_, err = Get[int](other, "SELECT * FROM does_not_exist")
+	if IsRetryable(DriverSqlite, err) {
+		t.Fatalf("Expected a missing table not to be retryable: %v", err)
+	}
+	if IsRetryable(DriverSqlite, nil) {
+		t.Fatal("Expected nil not to be retryable.")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    50,
+		InitialBackoff: 5 * time.Millisecond,
+		MaxBackoff:     20 * time.Millisecond,
+		Driver:         DriverSqlite,
+	}
+
+	t.Run("statement succeeds once unlocked", func(t *testing.T) {
+		_, other, unlock := lockedSqlite(t)
+		time.AfterFunc(100*time.Millisecond, unlock)
+
+		attempts := 0
+		err := Retry(context.Background(), policy, func(ctx context.Context) error {
+			attempts++
+			return ExecContext(ctx, other, "INSERT INTO locked (value) VALUES ($1)", "retried")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attempts < 2 {
+			t.Fatalf("Expected the insert to be retried, but it ran %d times", attempts)
+		}
+	})
+
+	t.Run("transaction succeeds once unlocked", func(t *testing.T) {
+		locker, other, unlock := lockedSqlite(t)
+		time.AfterFunc(100*time.Millisecond, unlock)
+
+		attempts := 0
+		err := WithTxRetry(context.Background(), other, policy, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+			attempts++
+			if err := ExecContext(ctx, other, "INSERT INTO locked (value) VALUES ($1)", "first"); err != nil {
+				return err
+			}
+			return ExecContext(ctx, other, "INSERT INTO locked (value) VALUES ($1)", "second")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attempts < 2 {
+			t.Fatalf("Expected the transaction to be retried, but it ran %d times", attempts)
+		}
+		values, err := Select[[]string](locker, "SELECT value FROM locked ORDER BY id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertStructEqual(t, []string{"first", "second"}, values, "Expected the retried transaction to be committed once")
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		_, other, _ := lockedSqlite(t)
+
+		buf := bytes.Buffer{}
+		logger := zerolog.New(&buf).Level(zerolog.TraceLevel)
+		attempts := 0
+		err := Retry(context.Background(), RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Logger: &logger}, func(ctx context.Context) error {
+			attempts++
+			return ExecContext(ctx, other, "INSERT INTO locked (value) VALUES ($1)", "never")
+		})
+		if !IsRetryable(DriverSqlite, err) {
+			t.Fatalf("Expected the last busy error to be returned, got: %v", err)
+		}
+		if attempts != 3 {
+			t.Fatalf("Expected 3 attempts, got %d", attempts)
+		}
+		if n := strings.Count(buf.String(), `"message":"RETRY"`); n != 2 {
+			t.Fatalf("Expected the 2 retries to be logged to the logger, got %d:\n%s", n, buf.String())
+		}
+	})
+
+	t.Run("does not retry other errors", func(t *testing.T) {
+		errExpected := errors.New("expected")
+		attempts := 0
+		err := Retry(context.Background(), policy, func(ctx context.Context) error {
+			attempts++
+			return errExpected
+		})
+		if !errors.Is(err, errExpected) {
+			t.Fatalf("Expected error to be returned, got: %v", err)
+		}
+		if attempts != 1 {
+			t.Fatalf("Expected 1 attempt, got %d", attempts)
+		}
+	})
+
+	t.Run("stops when the context is done", func(t *testing.T) {
+		_, other, _ := lockedSqlite(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		err := Retry(ctx, RetryPolicy{MaxAttempts: 1000, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond}, func(ctx context.Context) error {
+			return ExecContext(ctx, other, "INSERT INTO locked (value) VALUES ($1)", "never")
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected the context error to be returned, got: %v", err)
+		}
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		p := RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond, Jitter: -1}.withDefaults()
+		expected := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond}
+		for i, e := range expected {
+			if got := p.backoff(i + 1); got != e {
+				t.Fatalf("Expected backoff %s for attempt %d, got %s", e, i+1, got)
+			}
+		}
+
+		p = RetryPolicy{InitialBackoff: 100 * time.Millisecond, Jitter: 0.5}.withDefaults()
+		for range 100 {
+			if got := p.backoff(1); got < 50*time.Millisecond || got > 150*time.Millisecond {
+				t.Fatalf("Expected jittered backoff between 50ms and 150ms, got %s", got)
+			}
+		}
+	})
+}
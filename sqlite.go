@@ -0,0 +1,181 @@
+package boilerplate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Value of `PRAGMA synchronous`, see https://www.sqlite.org/pragma.html#pragma_synchronous.
+type SqliteSynchronous string
+
+const (
+	SynchronousOff    SqliteSynchronous = "OFF"
+	SynchronousNormal SqliteSynchronous = "NORMAL"
+	SynchronousFull   SqliteSynchronous = "FULL"
+	SynchronousExtra  SqliteSynchronous = "EXTRA"
+)
+
+// Pragmas sqlite only applies to the connection they are run on. These are passed to the driver in the DSN, so they are run on every connection the pool opens rather than only the one that happened to run them.
+type SqliteOptions struct {
+	// Enforces FOREIGN KEY constraints, which sqlite ignores by default.
+	ForeignKeys bool
+	// Switches to the write-ahead log, so reads do not wait for a write to finish. Ignored for in-memory databases.
+	WAL bool
+	// How long a statement waits for a lock held by another connection before failing with "database is locked". 0 fails right away.
+	BusyTimeout time.Duration
+	// Empty keeps the sqlite default of FULL. NORMAL is safe from corruption with `WAL`, and only risks losing the last commits on power loss.
+	Synchronous SqliteSynchronous
+	// Any other pragmas, without the PRAGMA keyword, i.e. "cache_size = -20000".
+	Pragmas []string
+}
+
+func (o SqliteOptions) pragmas() []string {
+	pragmas := []string{}
+	if o.ForeignKeys {
+		pragmas = append(pragmas, "foreign_keys = ON")
+	}
+	if o.WAL {
+		pragmas = append(pragmas, "journal_mode = WAL")
+	}
+	if o.BusyTimeout > 0 {
+		pragmas = append(pragmas, fmt.Sprintf("busy_timeout = %d", o.BusyTimeout.Milliseconds()))
+	}
+	if o.Synchronous != "" {
+		pragmas = append(pragmas, "synchronous = "+string(o.Synchronous))
+	}
+	return append(pragmas, o.Pragmas...)
+}
+
+// Adds the pragmas of `o` to the sqlite connection string `connString`, i.e. a file name, ":memory:" or a "file:" URI.
+//
+//	SqliteOptions{ForeignKeys: true}.DSN("app.db")  ->  app.db?_pragma=foreign_keys+%3D+ON
+func (o SqliteOptions) DSN(connString string) string {
+	pragmas := o.pragmas()
+	if len(pragmas) == 0 {
+		return connString
+	}
+	b := strings.Builder{}
+	b.WriteString(connString)
+	sep := "?"
+	if strings.Contains(connString, "?") {
+		sep = "&"
+	}
+	for _, pragma := range pragmas {
+		b.WriteString(sep)
+		b.WriteString("_pragma=")
+		b.WriteString(url.QueryEscape(pragma))
+		sep = "&"
+	}
+	return b.String()
+}
+
+type SqlitePoolOptions struct {
+	// Used for both pools, see `NewDB`.
+	DB DBOptions
+	// Applied to the connections of both pools. `WAL` is always turned on, so reads do not wait for the writer, and `BusyTimeout` defaults to 5 seconds for the locks other processes may still take.
+	Sqlite SqliteOptions
+	// Size of the read pool, defaults to the number of CPUs.
+	MaxReaders int
+	// Passed on to `ConnectWithOptions`, the pool sizes are set by `SqlitePool`.
+	Connect ConnectOptions
+}
+
+// A sqlite database opened as a single connection write pool and a read-only pool, which can be passed to the execute helpers like a single database.
+//
+// sqlite only allows one writer at a time, so concurrent writes from separate connections fail with "database is locked". Writes queue up for the one write connection instead, while GET, SELECT, NAMED_GET, NAMED_SELECT and SELECT_ITER run on the read pool. Every transaction opened by `WithTx` runs on the write connection, so always pass the context `WithTx` hands to `fn` to the helpers called inside it: a write made without it waits for the write connection the transaction is holding, forever.
+type SqlitePool struct {
+	writer *DB
+	reader *DB
+}
+
+var _ Querier = (*SqlitePool)(nil)
+
+// Opens both pools on `connString`, which has to be a file, as every connection to ":memory:" gets its own database.
+func ConnectSqlitePool(connString string, opts SqlitePoolOptions) (*SqlitePool, error) {
+	opts.Sqlite.WAL = true
+	if opts.Sqlite.BusyTimeout == 0 {
+		opts.Sqlite.BusyTimeout = 5 * time.Second
+	}
+	if opts.MaxReaders <= 0 {
+		opts.MaxReaders = runtime.NumCPU()
+	}
+
+	connect := opts.Connect
+	connect.Sqlite = opts.Sqlite
+	connect.MaxOpenConns = 1
+	connect.MaxIdleConns = 1
+	writer, err := ConnectWithOptions(DriverSqlite, connString, connect)
+	if err != nil {
+		return nil, err
+	}
+
+	connect.Sqlite.Pragmas = append(slices.Clip(connect.Sqlite.Pragmas), "query_only = ON")
+	connect.MaxOpenConns = opts.MaxReaders
+	connect.MaxIdleConns = opts.MaxReaders
+	reader, err := ConnectWithOptions(DriverSqlite, connString, connect)
+	if err != nil {
+		_ = writer.Close()
+		return nil, err
+	}
+
+	return &SqlitePool{writer: NewDB(writer, opts.DB), reader: NewDB(reader, opts.DB)}, nil
+}
+
+func (p *SqlitePool) Writer() *DB {
+	return p.writer
+}
+
+func (p *SqlitePool) Reader() *DB {
+	return p.reader
+}
+
+func (p *SqlitePool) Close() error {
+	return errors.Join(p.writer.Close(), p.reader.Close())
+}
+
+// Readers see every committed write right away, so unlike `Cluster` there is nothing to read your writes from.
+func (p *SqlitePool) route(ctx context.Context, write bool) *DB {
+	if write {
+		return p.writer
+	}
+	return p.reader
+}
+
+// The methods below make `SqlitePool` a `Querier` when used directly rather than through the execute helpers. Reads are routed like the helpers, `ExecContext` runs on the write connection.
+
+func (p *SqlitePool) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return p.reader.QueryContext(ctx, query, args...)
+}
+
+func (p *SqlitePool) QueryxContext(ctx context.Context, query string, args ...any) (*sqlx.Rows, error) {
+	return p.reader.QueryxContext(ctx, query, args...)
+}
+
+func (p *SqlitePool) QueryRowxContext(ctx context.Context, query string, args ...any) *sqlx.Row {
+	return p.reader.QueryRowxContext(ctx, query, args...)
+}
+
+func (p *SqlitePool) GetContext(ctx context.Context, dest any, query string, args ...any) error {
+	return p.reader.GetContext(ctx, dest, query, args...)
+}
+
+func (p *SqlitePool) SelectContext(ctx context.Context, dest any, query string, args ...any) error {
+	return p.reader.SelectContext(ctx, dest, query, args...)
+}
+
+func (p *SqlitePool) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return p.writer.ExecContext(ctx, query, args...)
+}
+
+func (p *SqlitePool) Rebind(query string) string {
+	return p.writer.Rebind(query)
+}
@@ -0,0 +1,179 @@
+package boilerplate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestSqliteOptionsDSN(t *testing.T) {
+	tests := []struct {
+		opts     SqliteOptions
+		dsn      string
+		expected string
+	}{
+		{SqliteOptions{}, "app.db", "app.db"},
+		{SqliteOptions{ForeignKeys: true}, "app.db", "app.db?_pragma=foreign_keys+%3D+ON"},
+		{SqliteOptions{BusyTimeout: 5 * time.Second, Synchronous: SynchronousNormal}, "file:app.db?mode=rwc", "file:app.db?mode=rwc&_pragma=busy_timeout+%3D+5000&_pragma=synchronous+%3D+NORMAL"},
+		{SqliteOptions{WAL: true, Pragmas: []string{"cache_size = -2000"}}, ":memory:", ":memory:?_pragma=journal_mode+%3D+WAL&_pragma=cache_size+%3D+-2000"},
+	}
+	for _, test := range tests {
+		if dsn := test.opts.DSN(test.dsn); dsn != test.expected {
+			t.Fatalf("Expected %q, got %q", test.expected, dsn)
+		}
+	}
+}
+
+func TestSqliteOptions(t *testing.T) {
+	connect := func(t *testing.T, opts SqliteOptions) *sqlx.DB {
+		db, err := ConnectWithOptions(DriverSqlite, filepath.Join(t.TempDir(), "options.db"), ConnectOptions{
+			MaxOpenConns: 4,
+			Sqlite:       opts,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+		for _, query := range []string{
+			"CREATE TABLE parent (id INTEGER PRIMARY KEY)",
+			"CREATE TABLE child (id INTEGER PRIMARY KEY, parent_id INTEGER NOT NULL REFERENCES parent (id))",
+		} {
+			if err := Exec(db, query); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return db
+	}
+
+	// holds every connection of the pool at once, so each one is a separate sqlite connection
+	conns := func(t *testing.T, db *sqlx.DB) []*sqlx.Conn {
+		conns := []*sqlx.Conn{}
+		for range db.Stats().MaxOpenConnections {
+			conn, err := db.Connx(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = conn.Close() })
+			conns = append(conns, conn)
+		}
+		return conns
+	}
+
+	t.Run("pragmas apply to every pooled connection", func(t *testing.T) {
+		db := connect(t, SqliteOptions{
+			ForeignKeys: true,
+			WAL:         true,
+			BusyTimeout: 2 * time.Second,
+			Synchronous: SynchronousNormal,
+			Pragmas:     []string{"cache_size = -4000"},
+		})
+
+		type Pragmas struct {
+			ForeignKeys bool   `db:"foreign_keys"`
+			JournalMode string `db:"journal_mode"`
+			BusyTimeout int    `db:"busy_timeout"`
+			Synchronous int    `db:"synchronous"`
+			CacheSize   int    `db:"cache_size"`
+		}
+		for i, conn := range conns(t, db) {
+			pragmas, err := Get[Pragmas](conn, `SELECT
+				(SELECT foreign_keys FROM pragma_foreign_keys) AS foreign_keys,
+				(SELECT journal_mode FROM pragma_journal_mode) AS journal_mode,
+				(SELECT timeout FROM pragma_busy_timeout) AS busy_timeout,
+				(SELECT synchronous FROM pragma_synchronous) AS synchronous,
+				(SELECT cache_size FROM pragma_cache_size) AS cache_size`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			AssertStructEqual(t, Pragmas{true, "wal", 2000, 1, -4000}, pragmas, fmt.Sprintf("Expected the pragmas to be set on connection %d", i))
+
+			err = Exec(conn, "INSERT INTO child (parent_id) VALUES (?)", 404)
+			if !errors.Is(err, ErrForeignKeyViolation) {
+				t.Fatalf("Expected the foreign key to be enforced on connection %d, got: %v", i, err)
+			}
+		}
+	})
+
+	t.Run("foreign keys are off by default", func(t *testing.T) {
+		db := connect(t, SqliteOptions{})
+		for i, conn := range conns(t, db) {
+			if err := Exec(conn, "INSERT INTO child (parent_id) VALUES (?)", 404); err != nil {
+				t.Fatalf("Expected sqlite to ignore the foreign key on connection %d, got: %v", i, err)
+			}
+		}
+	})
+}
+
+func TestSqlitePool(t *testing.T) {
+	pool, err := ConnectSqlitePool(filepath.Join(t.TempDir(), "pool.db"), SqlitePoolOptions{MaxReaders: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pool.Close() })
+
+	if err := Exec(pool, "CREATE TABLE events (id INTEGER PRIMARY KEY, worker INTEGER NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("concurrent writes are serialized", func(t *testing.T) {
+		const workers, writes = 8, 25
+		wg := sync.WaitGroup{}
+		errs := make(chan error, workers*(writes*2+1))
+		for worker := range workers {
+			wg.Go(func() {
+				for range writes {
+					errs <- Exec(pool, "INSERT INTO events (worker) VALUES (?)", worker)
+					_, err := Get[int](pool, "SELECT COUNT(*) FROM events WHERE worker = ?", worker)
+					errs <- err
+				}
+			})
+			wg.Go(func() {
+				errs <- WithTx(context.Background(), pool, nil, func(ctx context.Context, tx *sqlx.Tx) error {
+					if err := ExecContext(ctx, pool, "INSERT INTO events (worker) VALUES (?)", -1); err != nil {
+						return err
+					}
+					// reads through the context see the uncommitted row
+					n, err := GetContext[int](ctx, pool, "SELECT COUNT(*) FROM events WHERE id = last_insert_rowid()")
+					if err == nil && n != 1 {
+						err = fmt.Errorf("expected the transaction to see its own insert, got %d rows", n)
+					}
+					return err
+				})
+			})
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		n, err := Get[int](pool, "SELECT COUNT(*) FROM events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != workers*writes+workers {
+			t.Fatalf("Expected %d rows, got %d", workers*writes+workers, n)
+		}
+	})
+
+	t.Run("the read pool is read-only", func(t *testing.T) {
+		if err := Exec(pool.Reader(), "INSERT INTO events (worker) VALUES (?)", 0); err == nil {
+			t.Fatal("Expected a write on the read pool to fail")
+		}
+		mode, err := Get[string](pool.Reader(), "PRAGMA journal_mode")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode != "wal" {
+			t.Fatalf("Expected the database to use WAL, got %s", mode)
+		}
+	})
+}
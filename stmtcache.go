@@ -0,0 +1,129 @@
+package boilerplate
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// An LRU cache of prepared statements, keyed by the query text as it is sent to the driver, i.e. after named args are bound and the query is rebound.
+//
+// A `*sql.Stmt` is prepared lazily on every connection it runs on, and database/sql drops the connection's copy once that connection is closed, so statements stay valid as connections are recycled by `SetConnMaxLifetime` or `SetMaxIdleConns`.
+type stmtCache struct {
+	db   *sqlx.DB
+	size int
+
+	mu sync.Mutex
+	// Of *cachedStmt, most recently used first.
+	lru   *list.List
+	stmts map[string]*list.Element
+}
+
+type cachedStmt struct {
+	query string
+	stmt  *sqlx.Stmt
+}
+
+func newStmtCache(db *sqlx.DB, size int) *stmtCache {
+	return &stmtCache{
+		db:    db,
+		size:  size,
+		lru:   list.New(),
+		stmts: map[string]*list.Element{},
+	}
+}
+
+// Returns the prepared statement for `query`, preparing it if it is not cached yet.
+func (c *stmtCache) get(ctx context.Context, query string) (*sqlx.Stmt, error) {
+	c.mu.Lock()
+	if el, ok := c.stmts[query]; ok {
+		c.lru.MoveToFront(el)
+		c.mu.Unlock()
+		return el.Value.(*cachedStmt).stmt, nil
+	}
+	c.mu.Unlock()
+
+	// prepared without holding the lock, so a slow prepare does not block cache hits
+	stmt, err := c.db.PreparexContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.stmts[query]; ok {
+		// prepared concurrently by another caller
+		_ = stmt.Close()
+		c.lru.MoveToFront(el)
+		return el.Value.(*cachedStmt).stmt, nil
+	}
+	c.stmts[query] = c.lru.PushFront(&cachedStmt{query: query, stmt: stmt})
+	for c.lru.Len() > c.size {
+		c.removeLocked(c.lru.Back())
+	}
+	return stmt, nil
+}
+
+// Removes `stmt` from the cache, unless it has already been replaced.
+func (c *stmtCache) evict(query string, stmt *sqlx.Stmt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.stmts[query]; ok && el.Value.(*cachedStmt).stmt == stmt {
+		c.removeLocked(el)
+	}
+}
+
+// Closes and removes every statement.
+func (c *stmtCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() > 0 {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+// database/sql defers closing the statement on a connection until the queries running on it are done, so evicted statements can be closed right away.
+func (c *stmtCache) removeLocked(el *list.Element) {
+	entry := c.lru.Remove(el).(*cachedStmt)
+	delete(c.stmts, entry.query)
+	_ = entry.stmt.Close()
+}
+
+func (c *stmtCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// How often `withStmt` prepares a statement again before giving up.
+const maxStmtAttempts = 3
+
+// Runs `fn` with the cached statement for `query`. If the statement went stale because the schema changed, or was evicted and closed while in use, it is prepared again and `fn` is retried.
+func withStmt[T any](ctx context.Context, c *stmtCache, query string, fn func(stmt *sqlx.Stmt) (T, error)) (t T, err error) {
+	for attempt := 1; ; attempt++ {
+		stmt, err := c.get(ctx, query)
+		if err != nil {
+			return t, err
+		}
+		t, err = fn(stmt)
+		if attempt >= maxStmtAttempts || !isStaleStmt(err) {
+			return t, err
+		}
+		c.evict(query, stmt)
+	}
+}
+
+// Reports whether `err` means a prepared statement has to be prepared again, i.e. postgres refusing to run it once a table it selects from has changed. sqlite re-prepares such statements itself.
+func isStaleStmt(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "0A000" && strings.Contains(pqErr.Message, "cached plan must not change result type")
+	}
+	// evicted by another caller while in use, database/sql does not export this error
+	return err != nil && err.Error() == "sql: statement is closed"
+}
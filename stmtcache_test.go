@@ -0,0 +1,274 @@
+package boilerplate
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog"
+)
+
+func TestStatementCache(t *testing.T) {
+	sqliteDB, err := Connect(DriverSqlite, filepath.Join(t.TempDir(), "stmts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sqliteDB.Close() })
+
+	type Table struct {
+		ID    int    `db:"id" dbtype:"INTEGER NOT NULL PRIMARY KEY"`
+		Value string `db:"value" dbtype:"TEXT NOT NULL"`
+	}
+	queries := GenerateQueries(GenerateQueriesOptions{
+		TableName:          "table_stmts",
+		Model:              Table{},
+		AutoGeneratingCols: []string{"id"},
+		PrimaryKeys:        []string{"id"},
+		Driver:             DriverSqlite,
+	})
+
+	cached := NewDB(sqliteDB, DBOptions{Hooks: []QueryHook{}, StatementCacheSize: 2})
+	if err := Exec(cached, queries.CreateTable); err != nil {
+		t.Fatal(err)
+	}
+	cached.ResetStatementCache()
+
+	cachedQueries := func() []string {
+		result := []string{}
+		for el := cached.stmts.lru.Front(); el != nil; el = el.Next() {
+			result = append(result, el.Value.(*cachedStmt).query)
+		}
+		return result
+	}
+
+	t.Run("helpers", func(t *testing.T) {
+		row := Table{Value: "a"}
+		if err := NamedExecReturning(cached, &row, queries.Insert, &row); err != nil {
+			t.Fatal(err)
+		}
+		if err := NamedExec(cached, nil, queries.Insert, Table{Value: "b"}); err != nil {
+			t.Fatal(err)
+		}
+		AssertStructEqual(t, []string{"INSERT INTO table_stmts (value) VALUES (?) RETURNING *"}, cachedQueries(), "Expected the bound named query to be cached once")
+
+		got, err := Get[Table](cached, "SELECT * FROM table_stmts WHERE id = $1", row.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertStructEqual(t, row, got, "Expected the cached statement to return the row")
+
+		rows, err := Select[[]Table](cached, queries.Select)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 2 {
+			t.Fatalf("Expected 2 rows, got %d", len(rows))
+		}
+		AssertStructEqual(t, []string{queries.Select, "SELECT * FROM table_stmts WHERE id = $1"}, cachedQueries(), "Expected the least recently used statement to be evicted")
+
+		if _, err := Get[Table](cached, "SELECT * FROM table_stmts WHERE id = $1", row.ID); err != nil {
+			t.Fatal(err)
+		}
+		AssertStructEqual(t, []string{"SELECT * FROM table_stmts WHERE id = $1", queries.Select}, cachedQueries(), "Expected a cache hit to move the statement to the front")
+
+		for row, err := range SelectIter[Table](cached, queries.Select) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if row.ID == 0 {
+				t.Fatal("Expected the iterated row to be scanned")
+			}
+		}
+	})
+
+	t.Run("recycled connections", func(t *testing.T) {
+		// every query runs on a new connection
+		sqliteDB.SetMaxIdleConns(0)
+		sqliteDB.SetConnMaxLifetime(time.Millisecond)
+		t.Cleanup(func() {
+			sqliteDB.SetMaxIdleConns(2)
+			sqliteDB.SetConnMaxLifetime(0)
+		})
+
+		for i := range 5 {
+			count, err := Get[int](cached, "SELECT COUNT(*) FROM table_stmts")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != 2+i {
+				t.Fatalf("Expected %d rows, got %d", 2+i, count)
+			}
+			if err := Exec(cached, "INSERT INTO table_stmts (value) VALUES ($1)", "c"); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(2 * time.Millisecond)
+		}
+		if sqliteDB.Stats().MaxLifetimeClosed+sqliteDB.Stats().MaxIdleClosed == 0 {
+			t.Fatal("Expected connections to be recycled")
+		}
+	})
+
+	t.Run("schema change", func(t *testing.T) {
+		if _, err := Select[[]Table](cached, queries.Select); err != nil {
+			t.Fatal(err)
+		}
+		if err := Exec(cached, "ALTER TABLE table_stmts ADD COLUMN extra TEXT NOT NULL DEFAULT 'x'"); err != nil {
+			t.Fatal(err)
+		}
+		type Extended struct {
+			Table
+			Extra string `db:"extra"`
+		}
+		rows, err := Select[[]Extended](cached, queries.Select)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rows[0].Extra != "x" {
+			t.Fatalf("Expected the cached statement to pick up the new column, got %+v", rows[0])
+		}
+	})
+
+	t.Run("concurrent eviction", func(t *testing.T) {
+		small := NewDB(sqliteDB, DBOptions{Hooks: []QueryHook{}, StatementCacheSize: 1})
+		wg := sync.WaitGroup{}
+		errs := make(chan error, 8)
+		for i := range 8 {
+			wg.Go(func() {
+				for range 50 {
+					if _, err := Get[int](small, fmt.Sprintf("SELECT COUNT(*) + %d FROM table_stmts", i)); err != nil {
+						errs <- err
+						return
+					}
+				}
+			})
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal(err)
+		}
+		if small.stmts.len() != 1 {
+			t.Fatalf("Expected the cache to stay at 1 statement, got %d", small.stmts.len())
+		}
+	})
+
+	t.Run("stale postgres statement", func(t *testing.T) {
+		if !isStaleStmt(&pq.Error{Code: "0A000", Message: "cached plan must not change result type"}) {
+			t.Fatal("Expected a changed result type to need a new statement")
+		}
+		if isStaleStmt(&pq.Error{Code: "0A000", Message: "something else"}) || isStaleStmt(nil) {
+			t.Fatal("Expected other errors not to need a new statement")
+		}
+	})
+
+	t.Run("explain", func(t *testing.T) {
+		logger := zerolog.New(io.Discard)
+		explained := NewDB(sqliteDB, DBOptions{Logger: &logger, SlowQueryThreshold: time.Nanosecond, ExplainSlowQueries: true, StatementCacheSize: 2})
+		t.Cleanup(explained.ResetStatementCache)
+
+		if _, err := Get[int](explained, "SELECT COUNT(*) FROM table_stmts WHERE id > $1", 0); err != nil {
+			t.Fatal(err)
+		}
+		if n := explained.stmts.len(); n != 1 {
+			t.Fatalf("Expected only the query to be cached, not its EXPLAIN, got %d statements", n)
+		}
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		cached.ResetStatementCache()
+		if cached.stmts.len() != 0 {
+			t.Fatalf("Expected no cached statements, got %d", cached.stmts.len())
+		}
+	})
+}
+
+// modernc's sqlite driver prepares the query again every time a statement runs, so on sqlite this only measures the overhead of the cache, and the cached and uncached runs are within noise of each other. Set BOILERPLATE_POSTGRES_DSN to also run it against postgres, where statements are prepared on the server and the cache saves the parse and plan of every query.
+func BenchmarkStatementCache(b *testing.B) {
+	type Table struct {
+		ID    int    `db:"id" dbtype:"BIGSERIAL NOT NULL PRIMARY KEY"`
+		Value string `db:"value" dbtype:"TEXT NOT NULL"`
+	}
+
+	connect := map[Driver]func(b *testing.B) *sqlx.DB{
+		DriverSqlite: func(b *testing.B) *sqlx.DB {
+			sqliteDB, err := Connect(DriverSqlite, filepath.Join(b.TempDir(), "bench.db"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			return sqliteDB
+		},
+	}
+	if dsn := os.Getenv("BOILERPLATE_POSTGRES_DSN"); dsn != "" {
+		connect[DriverPostgres] = func(b *testing.B) *sqlx.DB {
+			postgresDB, err := Connect(DriverPostgres, dsn)
+			if err != nil {
+				b.Fatal(err)
+			}
+			return postgresDB
+		}
+	}
+
+	for _, driver := range []Driver{DriverSqlite, DriverPostgres} {
+		if connect[driver] == nil {
+			continue
+		}
+		opts := GenerateQueriesOptions{
+			TableName:          "table_bench",
+			Model:              Table{},
+			AutoGeneratingCols: []string{"id"},
+			PrimaryKeys:        []string{"id"},
+			Driver:             driver,
+		}
+		queries := GenerateQueries(opts)
+
+		for _, size := range []int{0, 16} {
+			b.Run(fmt.Sprintf("%s/cache=%d", driver, size), func(b *testing.B) {
+				bench := NewDB(connect[driver](b), DBOptions{Hooks: []QueryHook{}, StatementCacheSize: size})
+				b.Cleanup(func() {
+					_ = Exec(bench, queries.DropTable)
+					_ = bench.Close()
+				})
+
+				if err := Exec(bench, queries.CreateTable); err != nil {
+					b.Fatal(err)
+				}
+				rows := make([]Table, 1000)
+				for i := range rows {
+					rows[i].Value = fmt.Sprint(i)
+				}
+				if _, err := InsertMany(bench, opts, rows); err != nil {
+					b.Fatal(err)
+				}
+				ids, err := Select[[]int](bench, "SELECT id FROM table_bench")
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				b.Run("Get", func(b *testing.B) {
+					i := 0
+					for b.Loop() {
+						i++
+						if _, err := Get[Table](bench, "SELECT * FROM table_bench WHERE id = $1", ids[i%len(ids)]); err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+				b.Run("NamedExec", func(b *testing.B) {
+					i := 0
+					for b.Loop() {
+						i++
+						if err := NamedExec(bench, nil, "UPDATE table_bench SET value = :value WHERE id = :id", Table{ID: ids[i%len(ids)], Value: "x"}); err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+			})
+		}
+	}
+}